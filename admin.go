@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// adminFeed is a saved feed as the admin API shows it.
+type adminFeed struct {
+	Slug   string `json:"slug"`
+	Params params `json:"params"`
+	URL    string `json:"url"`
+	// InConfig feeds are saved in the config, the API can't change them
+	InConfig bool `json:"in_config,omitempty"`
+	// Credentials are only ever sent to the API, saving a feed without them
+	// keeps the ones it had and saving empty ones removes them
+	Credentials    *feedCredentials `json:"credentials,omitempty"`
+	HasCredentials bool             `json:"has_credentials,omitempty"`
+}
+
+// adminOnly lets only requests with the admin token through, or under
+// /u/<name>/ the user's token as well. Without any token configured there's
+// no admin API.
+func adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("user")
+		if !cfg().knownUser(user) {
+			http.NotFound(w, r)
+			return
+		}
+		tokens := []string{cfg().AdminToken, cfg().Users[user].Token}
+		tokens = slices.DeleteFunc(tokens, func(token string) bool { return token == "" })
+		if len(tokens) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !validToken(token, tokens) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rerss"`)
+			http.Error(w, "wrong or missing admin token", http.StatusUnauthorized)
+			return
+		}
+		// a reload can add tokens, but the database is only opened on start
+		if database == nil {
+			http.Error(w, "the admin API needs a restart to open the database", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func listFeedsHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	stored, err := storedFeeds(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	withCredentials, err := feedsWithCredentials(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	feeds := []adminFeed{}
+	inConfig := cfg().feeds(user)
+	for _, slug := range slices.Sorted(maps.Keys(inConfig)) {
+		feeds = append(feeds, newAdminFeed(r, slug, inConfig[slug], false))
+	}
+	for _, slug := range slices.Sorted(maps.Keys(stored)) {
+		if _, found := inConfig[slug]; !found {
+			feeds = append(feeds, newAdminFeed(r, slug, stored[slug], withCredentials[slug]))
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, feeds)
+}
+
+// presetsHandler lists the presets feeds can use, which only the config
+// changes.
+func presetsHandler(w http.ResponseWriter, r *http.Request) {
+	presets := map[string]params{}
+	maps.Copy(presets, cfg().presets(r.PathValue("user")))
+	writeAdminJSON(w, http.StatusOK, presets)
+}
+
+func getFeedHandler(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	feed, found, err := savedFeed(r.PathValue("user"), slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	credentials, err := storedCredentials(r.PathValue("user"), slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, newAdminFeed(r, slug, feed, credentials != nil))
+}
+
+// createFeedHandler saves a feed under a slug that isn't taken yet.
+func createFeedHandler(w http.ResponseWriter, r *http.Request) {
+	var feed adminFeed
+	if err := readAdminJSON(w, r, &feed); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, found, err := savedFeed(r.PathValue("user"), feed.Slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if found {
+		http.Error(w, fmt.Sprintf("%q is taken", feed.Slug), http.StatusConflict)
+		return
+	}
+	saveFeed(w, r, feed.Slug, feed, http.StatusCreated)
+}
+
+// putFeedHandler saves the feed at the slug, replacing what was there.
+func putFeedHandler(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	if _, inConfig := cfg().feeds(r.PathValue("user"))[slug]; inConfig {
+		http.Error(w, fmt.Sprintf("%q is saved in the config", slug), http.StatusConflict)
+		return
+	}
+	var feed adminFeed
+	if err := readAdminJSON(w, r, &feed); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if feed.Slug != "" && feed.Slug != slug {
+		http.Error(w, "the slug of the body isn't the one in the path", http.StatusBadRequest)
+		return
+	}
+	_, found, err := savedFeed(r.PathValue("user"), slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if !found {
+		status = http.StatusCreated
+	}
+	saveFeed(w, r, slug, feed, status)
+}
+
+func deleteFeedHandler(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	if _, inConfig := cfg().feeds(r.PathValue("user"))[slug]; inConfig {
+		http.Error(w, fmt.Sprintf("%q is saved in the config", slug), http.StatusConflict)
+		return
+	}
+	deleted, err := deleteStoredFeed(r.PathValue("user"), slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func saveFeed(w http.ResponseWriter, r *http.Request, slug string, feed adminFeed, status int) {
+	user := r.PathValue("user")
+	if err := checkSavedFeed(slug, feed.Params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var encrypted []byte
+	if feed.Credentials != nil && !feed.Credentials.empty() {
+		var err error
+		if encrypted, err = encryptCredentials(feed.Credentials); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := storeFeed(user, slug, feed.Params); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if feed.Credentials != nil {
+		if err := storeCredentials(user, slug, encrypted); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	stored, err := storedCredentials(user, slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	saved := newAdminFeed(r, slug, feed.Params, stored != nil)
+	w.Header().Set("Location", saved.URL)
+	writeAdminJSON(w, status, saved)
+}
+
+func newAdminFeed(r *http.Request, slug string, feed params, hasCredentials bool) adminFeed {
+	user := r.PathValue("user")
+	_, inConfig := cfg().feeds(user)[slug]
+	return adminFeed{Slug: slug, Params: feed, URL: baseURL(r) + feedPath(user, slug), InConfig: inConfig, HasCredentials: hasCredentials}
+}
+
+func readAdminJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return errors.New("invalid JSON: more than one value")
+	}
+	return nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// exportFeedsHandler lists the saved feeds as OPML, pointing at where they're
+// served, to subscribe to all of them at once.
+func exportFeedsHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	feeds, err := allSavedFeeds(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := &opml{Title: "rerss"}
+	for _, slug := range slices.Sorted(maps.Keys(feeds)) {
+		text := slug
+		if title := feeds[slug]["title"]; len(title) > 0 {
+			text = title[0]
+		}
+		list.Body = append(list.Body, &opmlOutline{Text: text, Type: "rss", XMLURL: baseURL(r) + feedPath(user, slug)})
+	}
+	if err := writeOPML(w, list); err != nil {
+		log.Printf("writing OPML: %v", err)
+	}
+}
+
+// importFeedsHandler saves a feed for every feed in the OPML of the body,
+// named after its title and made with the parameters of the query, like
+// ?preset=no-politics. Feeds already saved the same way are left as they are.
+func importFeedsHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	list := &opml{}
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, opmlMaxSize)).Decode(list); err != nil {
+		http.Error(w, fmt.Sprintf("invalid OPML: %v", err), http.StatusBadRequest)
+		return
+	}
+	feeds, err := allSavedFeeds(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	saved := map[string]bool{}
+	for _, feed := range feeds {
+		saved[feed.values().Encode()] = true
+	}
+
+	imported := []adminFeed{}
+	for _, outline := range list.feedOutlines() {
+		feed := params{}
+		for key, values := range r.URL.Query() {
+			feed[key] = values
+		}
+		feed["url"] = stringList{outline.XMLURL}
+		if saved[feed.values().Encode()] {
+			continue
+		}
+		title := outline.Title
+		if title == "" {
+			title = outline.Text
+		}
+		slug := slugOf(title)
+		for n := 2; feeds[slug] != nil; n++ {
+			slug = fmt.Sprintf("%s-%d", slugOf(title), n)
+		}
+		if err := checkSavedFeed(slug, feed); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := storeFeed(user, slug, feed); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		feeds[slug] = feed
+		saved[feed.values().Encode()] = true
+		imported = append(imported, newAdminFeed(r, slug, feed, false))
+	}
+	writeAdminJSON(w, http.StatusOK, imported)
+}
+
+// allSavedFeeds are the feeds of the user saved in the config and in the
+// database, by slug.
+func allSavedFeeds(user string) (map[string]params, error) {
+	feeds, err := storedFeeds(user)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(feeds, cfg().feeds(user))
+	return feeds, nil
+}
+
+// slugOf makes a slug of the title, like go-blog of "The Go Blog".
+func slugOf(title string) string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}) {
+		if word != "the" && word != "a" {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return "feed"
+	}
+	return strings.Join(words, "-")
+}
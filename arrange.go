@@ -0,0 +1,180 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+type arrangeFunc func(items []*gofeed.Item) []*gofeed.Item
+
+// parseArrange reads the parameters that reorder and truncate the items that
+// passed the filter.
+func parseArrange(query url.Values) (arrangeFunc, error) {
+	for _, mode := range query["dedup"] {
+		if !slices.Contains([]string{"title", "link", "guid", "fuzzy"}, mode) {
+			return nil, errors.New("'dedup' must be 'title', 'link', 'guid' or 'fuzzy'")
+		}
+	}
+	dedupModes := query["dedup"]
+
+	var compare func(a, b *gofeed.Item) int
+	switch query.Get("sort") {
+	case "":
+		if query.Has("order") {
+			compare = compareDates
+		}
+	case "date":
+		compare = compareDates
+	case "title":
+		compare = func(a, b *gofeed.Item) int {
+			return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+		}
+	default:
+		return nil, errors.New("'sort' must be 'date' or 'title'")
+	}
+
+	descending := query.Get("sort") != "title"
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		descending = false
+	case "desc":
+		descending = true
+	default:
+		return nil, errors.New("'order' must be 'asc' or 'desc'")
+	}
+
+	limit := -1
+	if query.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 0 {
+			return nil, errors.New("'limit' must be a non-negative number")
+		}
+	}
+
+	return func(items []*gofeed.Item) []*gofeed.Item {
+		if len(dedupModes) > 0 {
+			items = dedup(items, dedupModes)
+		}
+		if compare != nil {
+			slices.SortStableFunc(items, func(a, b *gofeed.Item) int {
+				if descending {
+					return compare(b, a)
+				}
+				return compare(a, b)
+			})
+		}
+		if limit >= 0 && len(items) > limit {
+			items = items[:limit]
+		}
+		return items
+	}, nil
+}
+
+// fuzzySimilarity is how many of their words two titles need to share to be
+// considered the same story.
+const fuzzySimilarity = 0.8
+
+// dedup drops items that repeat an earlier one by any of the modes.
+func dedup(items []*gofeed.Item, modes []string) []*gofeed.Item {
+	seen := map[string]bool{}
+	var keptTitles [][]string
+	return slices.DeleteFunc(items, func(item *gofeed.Item) bool {
+		var keys []string
+		var titleWords []string
+		for _, mode := range modes {
+			switch mode {
+			case "title":
+				keys = append(keys, "title:"+strings.Join(normalizeWords(item.Title, true), " "))
+			case "link":
+				if item.Link != "" {
+					keys = append(keys, "link:"+normalizeLink(item.Link))
+				}
+			case "guid":
+				if item.GUID != "" {
+					keys = append(keys, "guid:"+item.GUID)
+				}
+			case "fuzzy":
+				titleWords = normalizeWords(item.Title, true)
+			}
+		}
+
+		duplicate := slices.ContainsFunc(keys, func(key string) bool { return seen[key] })
+		if titleWords != nil && !duplicate {
+			duplicate = slices.ContainsFunc(keptTitles, func(kept []string) bool {
+				return similarity(titleWords, kept) >= fuzzySimilarity
+			})
+		}
+		if duplicate {
+			return true
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if titleWords != nil {
+			keptTitles = append(keptTitles, titleWords)
+		}
+		return false
+	})
+}
+
+// normalizeLink makes links that differ only in scheme, fragment, trailing
+// slash or tracking parameters equal.
+func normalizeLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") {
+			query.Del(key)
+		}
+	}
+	return strings.ToLower(u.Host) + strings.TrimSuffix(u.Path, "/") + "?" + query.Encode()
+}
+
+// similarity is the Jaccard index of two sets of words.
+func similarity(a, b []string) float64 {
+	setA := map[string]bool{}
+	for _, word := range a {
+		setA[word] = true
+	}
+	setB := map[string]bool{}
+	for _, word := range b {
+		setB[word] = true
+	}
+	shared := 0
+	for word := range setA {
+		if setB[word] {
+			shared++
+		}
+	}
+	total := len(setA) + len(setB) - shared
+	if total == 0 {
+		return 1
+	}
+	return float64(shared) / float64(total)
+}
+
+// compareDates orders undated items before everything else, so they end up
+// last with the default newest-first order.
+func compareDates(a, b *gofeed.Item) int {
+	dateA, dateB := itemDate(a), itemDate(b)
+	switch {
+	case dateA == nil && dateB == nil:
+		return 0
+	case dateA == nil:
+		return -1
+	case dateB == nil:
+		return 1
+	}
+	return cmp.Compare(dateA.UnixNano(), dateB.UnixNano())
+}
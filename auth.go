@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// keyRequired lets only requests with one of the API keys through, given as
+// ?key= or as a bearer token, or signed ones, when the config has keys or a
+// URL secret. The admin token works too, and so does the user's token under
+// /u/<name>/. The index page needs neither, and neither do the refreshes of
+// the pinned feeds, which the config allows.
+func keyRequired(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, refreshing := r.Context().Value(refreshingKey{}).(*time.Time)
+		if len(cfg().APIKeys) == 0 && cfg().URLSecret == "" || r.URL.RawQuery == "" || refreshing || validSignature(r) {
+			handler(w, r)
+			return
+		}
+		key := r.URL.Query().Get("key")
+		if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			key = bearer
+		}
+		allowed := append([]string{cfg().AdminToken, cfg().Users[r.PathValue("user")].Token}, cfg().APIKeys...)
+		if !validToken(key, allowed) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rerss"`)
+			http.Error(w, "wrong or missing key or signature", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// displayParams only change how a saved feed is shown, not what's in it.
+var displayParams = []string{"format", "limit", "style", "title", "desc", "key", "sig"}
+
+// keyRequiredToChange lets requests for saved feeds that only add
+// displayParams through, adding anything else makes another feed, which needs
+// a key like keyRequired's.
+func keyRequiredToChange(handler http.HandlerFunc) http.HandlerFunc {
+	keyed := keyRequired(handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if onlyDisplayParams(r.URL.Query()) {
+			handler(w, r)
+			return
+		}
+		keyed(w, r)
+	}
+}
+
+func onlyDisplayParams(query url.Values) bool {
+	for name := range query {
+		if !slices.Contains(displayParams, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// validToken is whether the token is one of the allowed ones, empty ones
+// allowing nothing.
+func validToken(token string, allowed []string) bool {
+	return token != "" && slices.ContainsFunc(allowed, func(allowed string) bool {
+		return allowed != "" && subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1
+	})
+}
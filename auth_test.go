@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func withConfig(t *testing.T, conf *config) {
+	t.Helper()
+	previous := cfg()
+	currentConfig.Store(conf)
+	t.Cleanup(func() { currentConfig.Store(previous) })
+}
+
+func served(handler http.HandlerFunc, r *http.Request) int {
+	recorder := httptest.NewRecorder()
+	handler(recorder, r)
+	return recorder.Code
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {}
+
+func TestKeyRequired(t *testing.T) {
+	withConfig(t, &config{APIKeys: []string{"k1"}, URLSecret: "s3cret", AdminToken: "admin"})
+	feed := url.Values{"url": {"https://example.com/feed.xml"}, "re": {"Go"}}
+	signed := signedURL("/", feed)
+
+	for _, test := range []struct {
+		name, target, bearer string
+		want                 int
+	}{
+		{"index page", "/", "", http.StatusOK},
+		{"no key", "/?" + feed.Encode(), "", http.StatusUnauthorized},
+		{"wrong key", "/?key=k2&" + feed.Encode(), "", http.StatusUnauthorized},
+		{"key", "/?key=k1&" + feed.Encode(), "", http.StatusOK},
+		{"key as bearer token", "/?" + feed.Encode(), "k1", http.StatusOK},
+		{"admin token", "/?" + feed.Encode(), "admin", http.StatusOK},
+		{"empty key", "/?key=&" + feed.Encode(), "", http.StatusUnauthorized},
+		{"signed", signed, "", http.StatusOK},
+		{"signed with a key", signed + "&key=k2", "", http.StatusOK},
+		{"signed and changed", signed + "&skip=Rust", "", http.StatusUnauthorized},
+		{"signed and moved", "/u/alice" + signed, "", http.StatusUnauthorized},
+		{"wrong signature", "/?sig=00&" + feed.Encode(), "", http.StatusUnauthorized},
+		{"bad signature", "/?sig=zz&" + feed.Encode(), "", http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+test.bearer)
+			}
+			if got := served(keyRequired(ok), r); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestKeyRequiredWithoutKeys(t *testing.T) {
+	withConfig(t, &config{AdminToken: "admin"})
+	r := httptest.NewRequest(http.MethodGet, "/?url=https://example.com/feed.xml", nil)
+	if got := served(keyRequired(ok), r); got != http.StatusOK {
+		t.Errorf("got %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestKeyRequiredRefreshing(t *testing.T) {
+	withConfig(t, &config{APIKeys: []string{"k1"}})
+	var next time.Time
+	ctx := context.WithValue(context.Background(), refreshingKey{}, &next)
+	r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/?url=https://example.com/feed.xml", nil)
+	if got := served(keyRequired(ok), r); got != http.StatusOK {
+		t.Errorf("got %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestKeyRequiredToChange(t *testing.T) {
+	withConfig(t, &config{APIKeys: []string{"k1"}})
+	for _, test := range []struct {
+		target string
+		want   int
+	}{
+		{"/f/go", http.StatusOK},
+		{"/f/go?format=json&limit=5&style=1", http.StatusOK},
+		{"/f/go?debug=1", http.StatusUnauthorized},
+		{"/f/go?debug=1&key=k1", http.StatusOK},
+		{"/f/go?title=Go&desc=Releases", http.StatusOK},
+		{"/f/go?url=https://example.com/feed.xml", http.StatusUnauthorized},
+		{"/f/go?opml=https://example.com/feeds.opml", http.StatusUnauthorized},
+		{"/f/go?fallback=https://example.com/feed.xml", http.StatusUnauthorized},
+		{"/f/go?blocklist=https://example.com/block.txt", http.StatusUnauthorized},
+		{"/f/go?item=article", http.StatusUnauthorized},
+		{"/f/go?map_title=name", http.StatusUnauthorized},
+		{"/f/go?format=json&preset=mine", http.StatusUnauthorized},
+		{"/f/go?url=https://example.com/feed.xml&key=k1", http.StatusOK},
+	} {
+		t.Run(test.target, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if got := served(keyRequiredToChange(ok), r); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// TestAdminOnlyWithoutDatabase checks the admin API of a reload that added a
+// token, before a restart opens the database.
+func TestAdminOnlyWithoutDatabase(t *testing.T) {
+	withConfig(t, &config{AdminToken: "admin"})
+	r := httptest.NewRequest(http.MethodGet, "/admin/feeds", nil)
+	if code := served(adminOnly(ok), r); code != http.StatusUnauthorized {
+		t.Errorf("without the token got %d, want %d", code, http.StatusUnauthorized)
+	}
+	r.Header.Set("Authorization", "Bearer admin")
+	if code := served(adminOnly(ok), r); code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// archive is the state as /admin/export dumps it and /admin/import loads it,
+// to move a server to another host. Under /u/<name>/ it's only the user's,
+// with their seen keys as they'd be without the /u/<name>/.
+type archive struct {
+	// Presets are the config's, for copying to the config on the other
+	// host, importing leaves them out
+	Presets map[string]params `json:"presets,omitempty"`
+	// Feeds are the ones saved through the admin API, with their credentials
+	// still encrypted with CREDENTIALS_KEY
+	Feeds []archivedFeed `json:"feeds"`
+	// Seen is when the items served with newonly=1 were last seen upstream,
+	// by item ID by feed and filter
+	Seen map[string]map[string]time.Time `json:"seen"`
+}
+
+type archivedFeed struct {
+	// User is who the feed is saved for, empty for the server's own
+	User        string `json:"user,omitempty"`
+	Slug        string `json:"slug"`
+	Params      params `json:"params"`
+	Credentials []byte `json:"credentials,omitempty"`
+}
+
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	state := &archive{Presets: cfg().Presets, Feeds: []archivedFeed{}, Seen: map[string]map[string]time.Time{}}
+	if user != "" {
+		state.Presets = cfg().Users[user].Presets
+	}
+	if err := exportFeeds(state, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := exportSeen(state, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="rerss.json"`)
+	writeAdminJSON(w, http.StatusOK, state)
+}
+
+// importHandler saves the feeds and seen items of the archive in the body,
+// replacing feeds at the same slugs. Nothing is saved if any of it is wrong.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	var state archive
+	if err := readAdminJSON(w, r, &state); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, feed := range state.Feeds {
+		if user != "" && feed.User != "" {
+			http.Error(w, fmt.Sprintf("feeds: %s: only the admin token imports feeds of other users", feed.Slug), http.StatusBadRequest)
+			return
+		}
+		if err := checkSavedFeed(feed.Slug, feed.Params); err != nil {
+			http.Error(w, fmt.Sprintf("feeds: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	for _, feed := range state.Feeds {
+		owner := feed.User
+		if user != "" {
+			owner = user
+		}
+		data, err := json.Marshal(feed.Params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec(`INSERT INTO feeds (owner, slug, params, credentials) VALUES (?, ?, ?, ?)
+			ON CONFLICT (owner, slug) DO UPDATE SET params = excluded.params, credentials = excluded.credentials`,
+			owner, feed.Slug, string(data), feed.Credentials); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	seen := 0
+	for key, ids := range state.Seen {
+		if user != "" {
+			key = user + ":" + key
+		}
+		for id, lastSeen := range ids {
+			// what's seen on both hosts was last seen when the later one saw it
+			if _, err := tx.Exec(`INSERT INTO seen (key, id, last_seen) VALUES (?, ?, ?)
+				ON CONFLICT (key, id) DO UPDATE SET last_seen = CASE
+					WHEN excluded.last_seen > seen.last_seen THEN excluded.last_seen ELSE seen.last_seen END`,
+				key, id, lastSeen.Unix()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			seen++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]int{"feeds": len(state.Feeds), "seen": seen})
+}
+
+// exportFeeds adds the feeds saved in the database to the archive, every
+// user's without a user.
+func exportFeeds(state *archive, user string) error {
+	query, args := `SELECT owner, slug, params, credentials FROM feeds ORDER BY owner, slug`, []any{}
+	if user != "" {
+		query, args = `SELECT owner, slug, params, credentials FROM feeds WHERE owner = ? ORDER BY slug`, []any{user}
+	}
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var feed archivedFeed
+		var data string
+		if err := rows.Scan(&feed.User, &feed.Slug, &data, &feed.Credentials); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(data), &feed.Params); err != nil {
+			return err
+		}
+		if user != "" {
+			feed.User = ""
+		}
+		state.Feeds = append(state.Feeds, feed)
+	}
+	return rows.Err()
+}
+
+// exportSeen adds the seen items to the archive, only the ones seen under
+// /u/<name>/ for a user.
+func exportSeen(state *archive, user string) error {
+	rows, err := database.Query(`SELECT key, id, last_seen FROM seen`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, id string
+		var lastSeen int64
+		if err := rows.Scan(&key, &id, &lastSeen); err != nil {
+			return err
+		}
+		if user != "" {
+			var found bool
+			if key, found = strings.CutPrefix(key, user+":"); !found {
+				continue
+			}
+		}
+		if state.Seen[key] == nil {
+			state.Seen[key] = map[string]time.Time{}
+		}
+		state.Seen[key][id] = time.Unix(lastSeen, 0).UTC()
+	}
+	return rows.Err()
+}
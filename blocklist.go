@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	blocklistTTL     = time.Hour
+	blocklistMaxSize = 1 << 20
+)
+
+// blocklist is a shared list of words and phrases to skip, one per line.
+// Lines like /pattern/ are regexes and lines starting with # are comments.
+type blocklist struct {
+	phrases []string
+	regexes []*regexp.Regexp
+	fetched time.Time
+}
+
+var (
+	blocklistsMu sync.Mutex
+	blocklists   = map[string]*blocklist{}
+	// blocklistClient is separate from the feed fetches so a slow list can't
+	// hold up the request for long.
+	blocklistClient = &http.Client{Timeout: 10 * time.Second, Transport: feedClient.Transport, CheckRedirect: checkRedirect}
+)
+
+// getBlocklist returns the list at url, fetching it at most once an hour. If
+// fetching fails the previous copy is used for as long as there is one.
+func getBlocklist(url string) (*blocklist, error) {
+	blocklistsMu.Lock()
+	cached := blocklists[url]
+	blocklistsMu.Unlock()
+	if cached != nil && time.Since(cached.fetched) < blocklistTTL {
+		return cached, nil
+	}
+
+	list, err := fetchBlocklist(url)
+	if err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, fmt.Errorf("blocklist %s: %w", url, err)
+	}
+
+	blocklistsMu.Lock()
+	blocklists[url] = list
+	blocklistsMu.Unlock()
+	return list, nil
+}
+
+func fetchBlocklist(url string) (*blocklist, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !allowedHost(req.URL.Hostname()) {
+		return nil, fmt.Errorf("%s isn't an allowed host", req.URL.Hostname())
+	}
+	resp, err := blocklistClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return parseBlocklist(io.LimitReader(resp.Body, blocklistMaxSize))
+}
+
+func parseBlocklist(r io.Reader) (*blocklist, error) {
+	list := &blocklist{fetched: time.Now()}
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/"):
+			regex, err := regexp.Compile(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			list.regexes = append(list.regexes, regex)
+		default:
+			list.phrases = append(list.phrases, line)
+		}
+	}
+	return list, scanner.Err()
+}
+
+func (list *blocklist) matches(text string, exact bool) bool {
+	return containsPhrase(text, list.phrases, exact) || anyMatch(list.regexes, text)
+}
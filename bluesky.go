@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+var blueskyProfilePath = regexp.MustCompile(`^/profile/([\w.:-]+)/?$`)
+
+// blueskyAPIURL is the posts of the Bluesky profile at the URL in the public
+// AppView API.
+func blueskyAPIURL(u *url.URL) (string, bool) {
+	if u.Host != "bsky.app" {
+		return "", false
+	}
+	match := blueskyProfilePath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return "", false
+	}
+	query := url.Values{"actor": {match[1]}, "limit": {"100"}}
+	return "https://public.api.bsky.app/xrpc/app.bsky.feed.getAuthorFeed?" + query.Encode(), true
+}
+
+type blueskyAuthorFeed struct {
+	Feed []struct {
+		Post struct {
+			URI    string `json:"uri"`
+			Author struct {
+				Handle      string `json:"handle"`
+				DisplayName string `json:"displayName"`
+			} `json:"author"`
+			Record struct {
+				Text      string    `json:"text"`
+				CreatedAt time.Time `json:"createdAt"`
+			} `json:"record"`
+			Embed struct {
+				Images []struct {
+					Fullsize string `json:"fullsize"`
+				} `json:"images"`
+			} `json:"embed"`
+		} `json:"post"`
+		Reply  *json.RawMessage `json:"reply"`
+		Reason *struct {
+			Type string `json:"$type"`
+		} `json:"reason"`
+	} `json:"feed"`
+}
+
+type blueskyParser struct{}
+
+// feed makes a feed of the profile's posts and reposts, which ones are
+// reposts and replies is kept for the filters like for Mastodon.
+func (blueskyParser) feed(_ context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	var authorFeed blueskyAuthorFeed
+	if err := json.Unmarshal(page, &authorFeed); err != nil {
+		return nil, fmt.Errorf("bluesky: %w", err)
+	}
+
+	var actor string
+	if api, err := url.Parse(pageURL); err == nil {
+		actor = api.Query().Get("actor")
+	}
+	feed := &gofeed.Feed{Title: actor, Link: "https://bsky.app/profile/" + actor, FeedType: "rss"}
+	for _, entry := range authorFeed.Feed {
+		post := entry.Post
+		link := fmt.Sprintf("https://bsky.app/profile/%s/post/%s", post.Author.Handle, post.URI[strings.LastIndex(post.URI, "/")+1:])
+		author := post.Author.DisplayName
+		if author == "" {
+			author = post.Author.Handle
+		}
+		published := post.Record.CreatedAt
+		item := &gofeed.Item{
+			Title:           truncate(strings.Join(strings.Fields(post.Record.Text), " "), postTitleLength),
+			Link:            link,
+			GUID:            post.URI,
+			Description:     strings.ReplaceAll(html.EscapeString(post.Record.Text), "\n", "<br>"),
+			Published:       published.Format(time.RFC3339),
+			PublishedParsed: &published,
+			Author:          &gofeed.Person{Name: author},
+			Authors:         []*gofeed.Person{{Name: author}},
+		}
+		for _, image := range post.Embed.Images {
+			item.Enclosures = append(item.Enclosures, &gofeed.Enclosure{URL: image.Fullsize, Type: "image/jpeg"})
+		}
+		repost := entry.Reason != nil && entry.Reason.Type == "app.bsky.feed.defs#reasonRepost"
+		setSiteValue(item, "social", "boost", strconv.FormatBool(repost))
+		setSiteValue(item, "social", "reply", strconv.FormatBool(entry.Reply != nil))
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
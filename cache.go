@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// responseCache keeps the filtered feeds served recently, so readers polling
+// the same one don't each make it fetch the upstream again. Past
+// cfg.CacheMaxSize the least recently used ones are dropped from memory.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+	// store keeps the responses beyond this process, across restarts or for
+	// other instances, nil keeps them in memory only
+	store cacheStore
+}
+
+// cacheStore keeps responses outside of memory.
+type cacheStore interface {
+	get(key string) (*cachedResponse, bool)
+	set(key string, response *cachedResponse)
+}
+
+type cachedResponse struct {
+	contentType string
+	body        []byte
+	// modified is when the feed last changed, zero if it's not known
+	modified time.Time
+	// nextRefresh is when a pinned feed is refreshed again
+	nextRefresh time.Time
+	expires     time.Time
+	// used is when it was last served from memory
+	used time.Time
+}
+
+var responses = &responseCache{entries: map[string]*cachedResponse{}}
+
+// staleRetention is how long an expired response is kept to be served
+// while it's refreshed.
+const staleRetention = 24 * time.Hour
+
+// get finds the response, fresh is false once it has expired. A response
+// that's missing or expired in memory is looked up in the store, where
+// another instance might have refreshed it.
+func (c *responseCache) get(key string) (response *cachedResponse, fresh, found bool) {
+	now := time.Now()
+	c.mu.Lock()
+	response, found = c.entries[key]
+	if found {
+		response.used = now
+	}
+	c.mu.Unlock()
+	if (!found || now.After(response.expires)) && c.store != nil {
+		if stored, ok := c.store.get(key); ok && (!found || stored.expires.After(response.expires)) {
+			response, found = stored, true
+			c.mu.Lock()
+			c.keep(key, response, now)
+			c.mu.Unlock()
+		}
+	}
+	if !found {
+		return nil, false, false
+	}
+	return response, now.Before(response.expires), true
+}
+
+// size is how many responses are kept in memory and how many bytes they take.
+func (c *responseCache) size() (count int, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, response := range c.entries {
+		bytes += len(response.body)
+	}
+	return len(c.entries), bytes
+}
+
+// set keeps the response for ttl.
+func (c *responseCache) set(key string, response *cachedResponse, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	response.expires = now.Add(ttl)
+	c.mu.Lock()
+	c.keep(key, response, now)
+	c.mu.Unlock()
+	if c.store != nil {
+		c.store.set(key, response)
+	}
+}
+
+// keep puts the response in memory, then drops the ones that have been stale
+// for too long and the least recently used while they take more than
+// cfg.CacheMaxSize. c.mu must be held.
+func (c *responseCache) keep(key string, response *cachedResponse, now time.Time) {
+	response.used = now
+	c.entries[key] = response
+	var size int64
+	for key, cached := range c.entries {
+		if now.After(cached.expires.Add(staleRetention)) {
+			delete(c.entries, key)
+			continue
+		}
+		size += int64(len(cached.body))
+	}
+	if size <= cfg().CacheMaxSize {
+		return
+	}
+	keys := slices.SortedFunc(maps.Keys(c.entries), func(a, b string) int {
+		return c.entries[a].used.Compare(c.entries[b].used)
+	})
+	for _, key := range keys {
+		if size <= cfg().CacheMaxSize {
+			break
+		}
+		size -= int64(len(c.entries[key].body))
+		delete(c.entries, key)
+	}
+}
+
+// storedResponse is how a response is kept in a store.
+type storedResponse struct {
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Modified    time.Time `json:"modified"`
+	NextRefresh time.Time `json:"next_refresh"`
+	Expires     time.Time `json:"expires"`
+}
+
+func encodeResponse(response *cachedResponse) ([]byte, error) {
+	return json.Marshal(storedResponse{
+		ContentType: response.contentType,
+		Body:        response.body,
+		Modified:    response.modified,
+		NextRefresh: response.nextRefresh,
+		Expires:     response.expires,
+	})
+}
+
+func decodeResponse(data []byte) (*cachedResponse, error) {
+	var stored storedResponse
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return &cachedResponse{
+		contentType: stored.ContentType,
+		body:        stored.Body,
+		modified:    stored.Modified,
+		nextRefresh: stored.NextRefresh,
+		expires:     stored.Expires,
+	}, nil
+}
+
+// cacheTTL is how long the feed made of the URLs is kept, the shortest time
+// of their hosts' configs or else the config's.
+func cacheTTL(urls []string) time.Duration {
+	ttl := cfg().CacheTTL
+	for i, feedURL := range urls {
+		hostTTL := cfg().CacheTTL
+		if u, err := url.Parse(feedURL); err == nil {
+			if host, ok := cfg().Hosts[u.Hostname()]; ok && host.CacheTTL != nil {
+				hostTTL = *host.CacheTTL
+			}
+		}
+		if i == 0 || hostTTL < ttl {
+			ttl = hostTTL
+		}
+	}
+	return ttl
+}
+
+// upstreamRetention is how long a fetched page is kept for conditional
+// requests after it was last fetched.
+const upstreamRetention = 24 * time.Hour
+
+// pageCache keeps the pages fetched with an ETag or Last-Modified, so fetching
+// them again can be a conditional request answered with 304 Not Modified.
+// They're kept by pageKey, so a page fetched with credentials is only reused
+// by fetches with the same ones.
+type pageCache struct {
+	mu    sync.Mutex
+	pages map[string]*cachedPage
+}
+
+type cachedPage struct {
+	etag, lastModified string
+	body               []byte
+	fetched            time.Time
+}
+
+var upstreamPages = &pageCache{pages: map[string]*cachedPage{}}
+
+// makeConditional asks for the page only if it changed since it was kept.
+func (c *pageCache) makeConditional(req *http.Request, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page, found := c.pages[key]
+	if !found {
+		return
+	}
+	if page.etag != "" {
+		req.Header.Set("If-None-Match", page.etag)
+	}
+	if page.lastModified != "" {
+		req.Header.Set("If-Modified-Since", page.lastModified)
+	}
+}
+
+// notModified is the kept page for a 304 response, nil if it's gone.
+func (c *pageCache) notModified(key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page, found := c.pages[key]
+	if !found {
+		return nil
+	}
+	page.fetched = time.Now()
+	return page.body
+}
+
+// store keeps the page if the response lets it be fetched conditionally,
+// dropping the pages that weren't fetched for a while.
+func (c *pageCache) store(key string, resp *http.Response, body []byte) {
+	page := &cachedPage{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         body,
+		fetched:      time.Now(),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for kept, cached := range c.pages {
+		if time.Since(cached.fetched) > upstreamRetention {
+			delete(c.pages, kept)
+		}
+	}
+	if page.etag == "" && page.lastModified == "" {
+		delete(c.pages, key)
+		return
+	}
+	c.pages[key] = page
+}
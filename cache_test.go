@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseCacheMaxSize(t *testing.T) {
+	withConfig(t, &config{CacheMaxSize: 10})
+	cache := &responseCache{entries: map[string]*cachedResponse{}}
+	for _, key := range []string{"a", "b"} {
+		cache.set(key, &cachedResponse{body: []byte("12345")}, time.Minute)
+	}
+	cache.get("a")
+	cache.set("c", &cachedResponse{body: []byte("12345")}, time.Minute)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, _, found := cache.get(key); found != want {
+			t.Errorf("%s kept %v, want %v", key, found, want)
+		}
+	}
+	if count, size := cache.size(); count != 2 || size != 10 {
+		t.Errorf("got %d responses of %d bytes", count, size)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const usage = `Usage:
+  rerss [serve] [-config file] [-addr address]
+      serves filtered feeds, at -addr like :8080 or else where the config says
+  rerss filter [-config file] -url feed -name value...
+      prints the feed filtered by the parameters, -name=value works too
+  rerss sign [-config file] -url feed -name value...
+      prints the address of the feed signed with the config's url_secret
+  rerss check-config [-config file]
+      checks the config
+  rerss version, rerss -version
+      prints the version the binary was built from
+
+The config is $CONFIG by default.
+`
+
+// filter prints the feed the parameters make, like the server would serve it.
+func filter(args []string) {
+	configPath, query := parseParams(args)
+	if err := setup(configPath); err != nil {
+		log.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/?"+query.Encode(), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	indexHandler(recorder, req)
+	if recorder.Code != http.StatusOK {
+		fmt.Fprint(os.Stderr, recorder.Body.String())
+		os.Exit(1)
+	}
+	os.Stdout.Write(recorder.Body.Bytes())
+}
+
+// sign prints the signed address of the feed the parameters make, to put
+// after the server's.
+func sign(args []string) {
+	configPath, query := parseParams(args)
+	conf, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if conf.URLSecret == "" {
+		fmt.Fprintln(os.Stderr, "no url_secret to sign with")
+		os.Exit(1)
+	}
+	currentConfig.Store(conf)
+	fmt.Println(signedURL("/", query))
+}
+
+// parseParams reads the -config and the feed's parameters, given as -name
+// value or -name=value.
+func parseParams(args []string) (configPath string, query url.Values) {
+	configPath, query = os.Getenv("CONFIG"), url.Values{}
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			fmt.Fprintf(os.Stderr, "%q isn't a -name\n\n%s", args[i], usage)
+			os.Exit(2)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !hasValue {
+			if i++; i == len(args) {
+				fmt.Fprintf(os.Stderr, "-%s needs a value\n\n%s", name, usage)
+				os.Exit(2)
+			}
+			value = args[i]
+		}
+		if name == "config" {
+			configPath = value
+		} else {
+			query.Add(name, value)
+		}
+	}
+	return configPath, query
+}
+
+func checkConfig(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	configPath := flags.String("config", os.Getenv("CONFIG"), "")
+	flags.Parse(args)
+	if *configPath == "" {
+		fmt.Fprintf(os.Stderr, "no config to check\n\n%s", usage)
+		os.Exit(2)
+	}
+	if _, err := loadConfig(*configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s is fine\n", *configPath)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encodings are the content encodings responses can be compressed with, in
+// the order they're preferred.
+var encodings = []string{"br", "gzip", "deflate"}
+
+// acceptedEncoding picks the encoding to compress with from Accept-Encoding,
+// "" for none.
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		accepted[name] = quality > 0
+	}
+	for _, encoding := range encodings {
+		if allowed, listed := accepted[encoding]; allowed || !listed && accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+func compress(body []byte, encoding string) ([]byte, error) {
+	var compressed bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "br":
+		w = brotli.NewWriter(&compressed)
+	case "gzip":
+		w = gzip.NewWriter(&compressed)
+	case "deflate":
+		w = zlib.NewWriter(&compressed)
+	default:
+		return body, nil
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
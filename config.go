@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	// IP and Port are where the server listens, IP is an IPv6 address with
+	// "::" for all of them
+	IP   string `yaml:"ip"`
+	Port string `yaml:"port"`
+	// ReadTimeout and WriteTimeout limit how long the server takes to read a
+	// request and write its response, 0 doesn't limit it
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// DataDir is where the database with the state is kept, like the items
+	// served with newonly=1 and the cache, without it the state is lost on
+	// restart
+	DataDir string `yaml:"data_dir"`
+	// DatabaseURL is a Postgres database to keep the state in instead, which
+	// several instances can share
+	DatabaseURL string `yaml:"database_url"`
+	// UserAgent is what feeds are fetched as
+	UserAgent string `yaml:"user_agent"`
+	// YouTubeAPIKey is a YouTube Data API key, for the durations of videos
+	YouTubeAPIKey string `yaml:"youtube_api_key"`
+	// AllowedHosts are the only hosts fetched from when set, along with their
+	// subdomains. Hosts at loopback, private or link-local addresses are only
+	// fetched from when listed themselves
+	AllowedHosts []string `yaml:"allowed_hosts"`
+
+	Presets map[string]params `yaml:"presets"`
+	// Feeds are saved feeds served at /f/<slug>, by slug. Each is the query
+	// parameters of the feed, url included.
+	Feeds map[string]params `yaml:"feeds"`
+	// AdminToken lets the admin API at /admin/ be used with it as the bearer
+	// token, without it the API is off
+	AdminToken string `yaml:"admin_token"`
+	// APIKeys are needed to make feeds when set, as ?key= or a bearer token,
+	// so the server isn't open to anyone. Saved feeds need none
+	APIKeys []string `yaml:"api_keys"`
+	// URLSecret signs feed URLs with ?sig=, signed ones need no key and stop
+	// working when anything in them changes
+	URLSecret string `yaml:"url_secret"`
+	// CredentialsKey encrypts the credentials of the feeds saved through the
+	// admin API, it's only read from the environment
+	CredentialsKey string `yaml:"-"`
+	// Users have their own saved feeds and presets on a shared server, under
+	// /u/<name>/, by name
+	Users map[string]userConfig `yaml:"users"`
+	// Hosts are settings for fetching from the hosts, by hostname
+	Hosts map[string]hostConfig `yaml:"hosts"`
+	// Proxy is what feeds are fetched through, instead of HTTP_PROXY and the
+	// like
+	Proxy string `yaml:"proxy"`
+	// FetchTimeout is how long a fetch from the upstream can take
+	FetchTimeout time.Duration `yaml:"fetch_timeout"`
+	// MaxFetches is how many fetches from upstreams can be made at once
+	MaxFetches int `yaml:"max_fetches"`
+	// MaxPageSize is how many bytes a page fetched from the upstream can have
+	MaxPageSize int64 `yaml:"max_page_size"`
+	// Retries is how many times a failed fetch is tried again, waiting
+	// RetryBackoff the first time and twice as long every next time, up to
+	// FetchTimeout
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// CacheTTL is how long filtered feeds are served from memory, 0 doesn't
+	// keep them
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// CacheDir keeps the cache in files instead of the database when set. Either
+	// takes up to CacheMaxSize bytes, and so does the cache in memory
+	CacheDir     string `yaml:"cache_dir"`
+	CacheMaxSize int64  `yaml:"cache_max_size"`
+	// Redis keeps the cache in Redis at this URL instead, shared by every
+	// instance using it
+	Redis string `yaml:"redis"`
+	// Pinned are feeds kept in the cache by refreshing them in the background
+	// every RefreshInterval, as the addresses the readers ask for
+	Pinned          []string      `yaml:"pinned"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// LogLevel is the least important of debug, info, warn and error that's
+	// logged, LogFormat is text or json
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"`
+	// ReadyCanary is a feed /readyz fetches, at most once a minute, to check
+	// that the upstreams can be reached
+	ReadyCanary string `yaml:"ready_canary"`
+	// OTLPEndpoint is the OTLP/HTTP collector the traces of the requests are
+	// sent to, none are without it
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+
+	// logLevel is LogLevel parsed.
+	logLevel slog.Level
+	// fetchSlots has room for MaxFetches fetches, more wait for one of them to
+	// end or give up after FetchTimeout.
+	fetchSlots chan struct{}
+}
+
+// userConfig is a user of a shared server. Their presets are used along with
+// the config's, and win over them.
+type userConfig struct {
+	// Token lets the user manage their saved feeds through the admin API at
+	// /u/<name>/admin/, the admin token works as well
+	Token   string            `yaml:"token"`
+	Presets map[string]params `yaml:"presets"`
+	Feeds   map[string]params `yaml:"feeds"`
+}
+
+// hostConfig is how to fetch from a host, kept on the server so private feeds
+// can be filtered without their credentials in the URL.
+type hostConfig struct {
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Headers  map[string]string `yaml:"headers"`
+	Cookies  map[string]string `yaml:"cookies"`
+	// Proxy is what the host is fetched through, "direct" for none
+	Proxy string `yaml:"proxy"`
+	// CacheTTL replaces the config's for feeds from the host
+	CacheTTL *time.Duration `yaml:"cache_ttl"`
+}
+
+// params are query parameters, each given as a single value or a list.
+type params map[string]stringList
+
+func (p params) values() url.Values {
+	values := url.Values{}
+	for key, list := range p {
+		values[key] = list
+	}
+	return values
+}
+
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*l = stringList{value}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// currentConfig is the config in use, a reload replaces it whole so a request
+// that already read it keeps it.
+var currentConfig atomic.Pointer[config]
+
+func init() {
+	currentConfig.Store(&config{})
+}
+
+func cfg() *config {
+	return currentConfig.Load()
+}
+
+// envOverrides are the settings the environment can override, by the
+// variable that does.
+func envOverrides(conf *config) map[string]*string {
+	return map[string]*string{
+		"IP":              &conf.IP,
+		"PORT":            &conf.Port,
+		"DATA_DIR":        &conf.DataDir,
+		"DATABASE_URL":    &conf.DatabaseURL,
+		"USER_AGENT":      &conf.UserAgent,
+		"YOUTUBE_API_KEY": &conf.YouTubeAPIKey,
+		"ADMIN_TOKEN":     &conf.AdminToken,
+		"URL_SECRET":      &conf.URLSecret,
+		"CREDENTIALS_KEY": &conf.CredentialsKey,
+		"LOG_LEVEL":       &conf.LogLevel,
+		"LOG_FORMAT":      &conf.LogFormat,
+
+		"OTEL_EXPORTER_OTLP_ENDPOINT": &conf.OTLPEndpoint,
+	}
+}
+
+// loadConfig reads the YAML config at path, an empty path means no config.
+// The environment overrides what's in it.
+func loadConfig(path string) (*config, error) {
+	conf := &config{
+		ReadTimeout:     10 * time.Second,
+		UserAgent:       defaultUserAgent,
+		FetchTimeout:    30 * time.Second,
+		MaxFetches:      32,
+		MaxPageSize:     10 << 20,
+		Retries:         2,
+		RetryBackoff:    time.Second,
+		CacheTTL:        5 * time.Minute,
+		CacheMaxSize:    100 << 20,
+		RefreshInterval: 15 * time.Minute,
+		LogLevel:        "info",
+		LogFormat:       "text",
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, conf); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for name, setting := range envOverrides(conf) {
+		if value := os.Getenv(name); value != "" {
+			*setting = value
+		}
+	}
+
+	if err := checkProxy(conf.Proxy); err != nil {
+		return nil, fmt.Errorf("%s: proxy: %w", path, err)
+	}
+	for name, host := range conf.Hosts {
+		if err := checkProxy(host.Proxy); err != nil {
+			return nil, fmt.Errorf("%s: hosts: %s: proxy: %w", path, name, err)
+		}
+	}
+	for slug, feed := range conf.Feeds {
+		if err := checkSavedFeed(slug, feed); err != nil {
+			return nil, fmt.Errorf("%s: feeds: %w", path, err)
+		}
+	}
+	if conf.AdminToken != "" && conf.DataDir == "" && conf.DatabaseURL == "" {
+		return nil, fmt.Errorf("%s: admin_token needs data_dir or database_url to save feeds in", path)
+	}
+	for name, user := range conf.Users {
+		if !feedSlug.MatchString(name) {
+			return nil, fmt.Errorf("%s: users: %q must be lowercase letters, digits and dashes", path, name)
+		}
+		for slug, feed := range user.Feeds {
+			if err := checkSavedFeed(slug, feed); err != nil {
+				return nil, fmt.Errorf("%s: users: %s: feeds: %w", path, name, err)
+			}
+		}
+		if user.Token != "" && conf.DataDir == "" && conf.DatabaseURL == "" {
+			return nil, fmt.Errorf("%s: users: %s: token needs data_dir or database_url to save feeds in", path, name)
+		}
+	}
+	for _, feedURL := range conf.Pinned {
+		pinned, err := url.Parse(feedURL)
+		if err != nil || pinned.Host == "" || pinned.RawQuery == "" && !strings.Contains(pinned.Path, "/f/") {
+			return nil, fmt.Errorf("%s: pinned: %q must be a full rerss URL", path, feedURL)
+		}
+		if pinned.Query().Get("newonly") == "1" {
+			return nil, fmt.Errorf("%s: pinned: %q would mark its items seen", path, feedURL)
+		}
+	}
+	if conf.ReadyCanary != "" {
+		if canary, err := url.Parse(conf.ReadyCanary); err != nil || canary.Host == "" {
+			return nil, fmt.Errorf("%s: ready_canary: %q must be a full URL", path, conf.ReadyCanary)
+		}
+	}
+	if conf.FetchTimeout <= 0 {
+		return nil, fmt.Errorf("%s: fetch_timeout must be positive", path)
+	}
+	if conf.MaxFetches <= 0 {
+		return nil, fmt.Errorf("%s: max_fetches must be positive", path)
+	}
+	if conf.MaxPageSize <= 0 {
+		return nil, fmt.Errorf("%s: max_page_size must be positive", path)
+	}
+	if conf.CacheDir != "" && conf.Redis != "" {
+		return nil, fmt.Errorf("%s: cache_dir and redis can't both be set", path)
+	}
+	if conf.CacheMaxSize <= 0 {
+		return nil, fmt.Errorf("%s: cache_max_size must be positive", path)
+	}
+	if err := conf.logLevel.UnmarshalText([]byte(conf.LogLevel)); err != nil {
+		return nil, fmt.Errorf("%s: log_level must be debug, info, warn or error", path)
+	}
+	if conf.LogFormat != "text" && conf.LogFormat != "json" {
+		return nil, fmt.Errorf("%s: log_format must be text or json", path)
+	}
+	if conf.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("%s: refresh_interval must be positive", path)
+	}
+	conf.fetchSlots = make(chan struct{}, conf.MaxFetches)
+	return conf, nil
+}
+
+// allowedHost is whether the host can be fetched from.
+func allowedHost(host string) bool {
+	if len(cfg().AllowedHosts) == 0 {
+		return true
+	}
+	for _, allowed := range cfg().AllowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyHost tells if the host is the proxy of the config or of one of its
+// hosts.
+func (c *config) proxyHost(host string) bool {
+	proxies := []string{c.Proxy}
+	for _, hostConfig := range c.Hosts {
+		proxies = append(proxies, hostConfig.Proxy)
+	}
+	return slices.ContainsFunc(proxies, func(proxy string) bool {
+		parsed, err := url.Parse(proxy)
+		return err == nil && parsed.Hostname() == host
+	})
+}
+
+// checkRedirect keeps the clients from following redirects to hosts that
+// aren't allowed, and what's sent only to the first host from following them
+// to others.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !allowedHost(req.URL.Hostname()) {
+		return fmt.Errorf("%s isn't an allowed host", req.URL.Hostname())
+	}
+	if req.URL.Host != via[0].URL.Host {
+		first := cfg().Hosts[via[0].URL.Hostname()]
+		for name := range first.Headers {
+			req.Header.Del(name)
+		}
+		if first.Username != "" {
+			req.Header.Del("Authorization")
+		}
+		// Go only drops cookies on the way to other domains, not to their
+		// subdomains
+		if len(first.Cookies) > 0 {
+			req.Header.Del("Cookie")
+		}
+		for name := range credentialsFor(req.Context(), via[0].URL.String()) {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+func checkProxy(proxy string) error {
+	if proxy == "" || proxy == "direct" {
+		return nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, proxyURL.Scheme) {
+		return fmt.Errorf("%q must be an http, https or socks5 URL", proxy)
+	}
+	return nil
+}
+
+// feedSlug is what the slugs of saved feeds look like.
+var feedSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func checkSavedFeed(slug string, feed params) error {
+	if !feedSlug.MatchString(slug) {
+		return fmt.Errorf("%q must be lowercase letters, digits and dashes", slug)
+	}
+	if len(feed["url"]) == 0 && len(feed["opml"]) == 0 {
+		return fmt.Errorf("%s: missing 'url' or 'opml'", slug)
+	}
+	return nil
+}
+
+// knownUser is whether the user is in the config, "" being no user.
+func (c *config) knownUser(user string) bool {
+	_, found := c.Users[user]
+	return user == "" || found
+}
+
+// presets are the presets the user can use, "" for the ones of no user.
+func (c *config) presets(user string) map[string]params {
+	if user == "" {
+		return c.Presets
+	}
+	presets := map[string]params{}
+	maps.Copy(presets, c.Presets)
+	maps.Copy(presets, c.Users[user].Presets)
+	return presets
+}
+
+// feeds are the feeds saved in the config for the user, "" for the ones of
+// no user.
+func (c *config) feeds(user string) map[string]params {
+	if user == "" {
+		return c.Feeds
+	}
+	return c.Users[user].Feeds
+}
+
+// feedPath is where the saved feed of the user is served.
+func feedPath(user, slug string) string {
+	if user == "" {
+		return "/f/" + slug
+	}
+	return "/u/" + user + "/f/" + slug
+}
+
+// applyPresets adds the parameters of every preset named in the query. They
+// come after the ones in the query, so single-valued parameters from the
+// query win and repeated ones combine.
+func applyPresets(query url.Values, presets map[string]params) error {
+	for _, name := range query["preset"] {
+		preset, found := presets[name]
+		if !found {
+			return fmt.Errorf("unknown preset %q", name)
+		}
+		for key, values := range preset {
+			query[key] = append(query[key], values...)
+		}
+	}
+	return nil
+}
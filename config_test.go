@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestAllowedHost(t *testing.T) {
+	withConfig(t, &config{})
+	if !allowedHost("anything.example") {
+		t.Error("without allowed_hosts every host is allowed")
+	}
+
+	withConfig(t, &config{AllowedHosts: []string{"example.com", "youtube.com"}})
+	for _, test := range []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"a.b.example.com", true},
+		{"youtube.com", true},
+		{"badexample.com", false},
+		{"example.com.evil.org", false},
+		{"example.org", false},
+		{"com", false},
+		{"", false},
+	} {
+		t.Run(test.host, func(t *testing.T) {
+			if got := allowedHost(test.host); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// feedCredentials are what a saved feed is fetched with from its URLs, like
+// the config's hosts. They're kept encrypted with CREDENTIALS_KEY
+// and the admin API never shows them.
+type feedCredentials struct {
+	Username string            `json:"username,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Cookies  map[string]string `json:"cookies,omitempty"`
+}
+
+func (c *feedCredentials) empty() bool {
+	return c.Username == "" && c.Password == "" && len(c.Headers) == 0 && len(c.Cookies) == 0
+}
+
+// header is the credentials as the headers of a request.
+func (c *feedCredentials) header() http.Header {
+	header := http.Header{}
+	for name, value := range c.Headers {
+		header.Set(name, value)
+	}
+	if c.Username != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password)))
+	}
+	var cookies []string
+	for _, name := range slices.Sorted(maps.Keys(c.Cookies)) {
+		cookies = append(cookies, (&http.Cookie{Name: name, Value: c.Cookies[name]}).String())
+	}
+	if len(cookies) > 0 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	return header
+}
+
+// credentialsKey holds the sourceCredentials of the saved feed being served in
+// the request context.
+type credentialsKey struct{}
+
+type sourceCredentials struct {
+	// urls are the feed's own, other pages on their hosts aren't fetched
+	// with the credentials
+	urls   []string
+	header http.Header
+}
+
+// withCredentials makes the fetches of the feed's URLs and fallbacks made in
+// ctx use the credentials, and of the APIs the site adapters read them from.
+func withCredentials(ctx context.Context, feed params, credentials *feedCredentials) context.Context {
+	var urls []string
+	for _, feedURL := range slices.Concat(feed["url"], feed["fallback"]) {
+		apiURL, _ := adapt(feedURL)
+		urls = append(urls, feedURL, apiURL)
+	}
+	return context.WithValue(ctx, credentialsKey{}, &sourceCredentials{urls: urls, header: credentials.header()})
+}
+
+// credentialsFor are the headers of the credentials in ctx for the page, nil
+// if it isn't one of the saved feed's.
+func credentialsFor(ctx context.Context, pageURL string) http.Header {
+	credentials, ok := ctx.Value(credentialsKey{}).(*sourceCredentials)
+	if !ok || !slices.Contains(credentials.urls, pageURL) {
+		return nil
+	}
+	return credentials.header
+}
+
+func credentialsCipher() (cipher.AEAD, error) {
+	if cfg().CredentialsKey == "" {
+		return nil, errors.New("credentials need CREDENTIALS_KEY to be encrypted with")
+	}
+	key := sha256.Sum256([]byte(cfg().CredentialsKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptCredentials(credentials *feedCredentials) ([]byte, error) {
+	aead, err := credentialsCipher()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func decryptCredentials(data []byte) (*feedCredentials, error) {
+	aead, err := credentialsCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("credentials are too short")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("credentials can't be decrypted, is CREDENTIALS_KEY the one they were saved with?")
+	}
+	credentials := &feedCredentials{}
+	if err := json.Unmarshal(plain, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
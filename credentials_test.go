@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCredentialsFor(t *testing.T) {
+	feed := params{
+		"url":      {"https://private.example.com/feed.xml", "https://github.com/owner/repo"},
+		"fallback": {"https://mirror.example.org/feed.xml"},
+	}
+	ctx := withCredentials(context.Background(), feed, &feedCredentials{Username: "user", Password: "pass"})
+
+	for _, test := range []struct {
+		pageURL string
+		want    bool
+	}{
+		{"https://private.example.com/feed.xml", true},
+		{"https://mirror.example.org/feed.xml", true},
+		{"https://api.github.com/repos/owner/repo/releases?per_page=100", true},
+		{"https://private.example.com/feed.xml?page=2", false},
+		{"https://private.example.com/other/page", false},
+		{"http://private.example.com/feed.xml", false},
+		{"https://other.example.com/feed.xml", false},
+	} {
+		t.Run(test.pageURL, func(t *testing.T) {
+			header := credentialsFor(ctx, test.pageURL)
+			if got := header.Get("Authorization") == "Basic dXNlcjpwYXNz"; got != test.want {
+				t.Errorf("got credentials %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	if header := credentialsFor(context.Background(), "https://private.example.com/feed.xml"); header != nil {
+		t.Errorf("got %v without credentials", header)
+	}
+}
+
+func TestEncryptCredentials(t *testing.T) {
+	withConfig(t, &config{CredentialsKey: "key"})
+	credentials := &feedCredentials{Username: "user", Password: "pass", Headers: map[string]string{"X-Token": "t"}}
+	encrypted, err := encryptCredentials(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decryptCredentials(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decrypted, credentials) {
+		t.Errorf("got %+v, want %+v", decrypted, credentials)
+	}
+
+	withConfig(t, &config{CredentialsKey: "another key"})
+	if _, err := decryptCredentials(encrypted); err == nil {
+		t.Error("decrypted with another key")
+	}
+	withConfig(t, &config{})
+	if _, err := encryptCredentials(credentials); err == nil {
+		t.Error("encrypted without a key")
+	}
+}
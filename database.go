@@ -0,0 +1,336 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// database keeps the state that has to survive restarts, in rerss.db in the
+// data dir or in Postgres. It's nil without either, and then the state lives
+// in memory.
+var database *stateDB
+
+// stateDB is the database of the state, SQLite or Postgres. Queries are
+// written for SQLite and have their placeholders rewritten for Postgres.
+type stateDB struct {
+	*sql.DB
+	postgres bool
+}
+
+func (db *stateDB) rebind(query string) string {
+	if !db.postgres {
+		return query
+	}
+	var rebound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&rebound, "$%d", n)
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
+
+func (db *stateDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.rebind(query), args...)
+}
+
+func (db *stateDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.rebind(query), args...)
+}
+
+func (db *stateDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.rebind(query), args...)
+}
+
+func (db *stateDB) Begin() (*stateTx, error) {
+	tx, err := db.DB.Begin()
+	return &stateTx{Tx: tx, db: db}, err
+}
+
+type stateTx struct {
+	*sql.Tx
+	db *stateDB
+}
+
+func (tx *stateTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.rebind(query), args...)
+}
+
+func (tx *stateTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.db.rebind(query), args...)
+}
+
+// migrations bring the database from one version to the next, the version
+// it's at is kept in its user_version.
+var migrations = []string{`
+CREATE TABLE IF NOT EXISTS feeds (
+	slug TEXT PRIMARY KEY,
+	params TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS seen (
+	key TEXT NOT NULL,
+	id TEXT NOT NULL,
+	last_seen INTEGER NOT NULL,
+	PRIMARY KEY (key, id)
+);
+CREATE INDEX IF NOT EXISTS seen_last_seen ON seen (last_seen);
+CREATE TABLE IF NOT EXISTS responses (
+	key TEXT PRIMARY KEY,
+	data BLOB NOT NULL,
+	size INTEGER NOT NULL,
+	expires INTEGER NOT NULL,
+	used INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS cache_stats (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	hits INTEGER NOT NULL,
+	stale INTEGER NOT NULL,
+	misses INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS host_stats (
+	host TEXT PRIMARY KEY,
+	fetches INTEGER NOT NULL,
+	errors INTEGER NOT NULL,
+	took INTEGER NOT NULL
+);
+`, `
+CREATE TABLE user_feeds (
+	owner TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	params TEXT NOT NULL,
+	PRIMARY KEY (owner, slug)
+);
+INSERT INTO user_feeds SELECT '', slug, params FROM feeds;
+DROP TABLE feeds;
+ALTER TABLE user_feeds RENAME TO feeds;
+`, `
+ALTER TABLE feeds ADD COLUMN credentials BLOB;
+`}
+
+// postgresMigrations are the migrations of Postgres, which started out at
+// what SQLite migrated to.
+var postgresMigrations = []string{`
+CREATE TABLE feeds (
+	owner TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	params TEXT NOT NULL,
+	credentials BYTEA,
+	PRIMARY KEY (owner, slug)
+);
+CREATE TABLE seen (
+	key TEXT NOT NULL,
+	id TEXT NOT NULL,
+	last_seen BIGINT NOT NULL,
+	PRIMARY KEY (key, id)
+);
+CREATE INDEX seen_last_seen ON seen (last_seen);
+CREATE TABLE responses (
+	key TEXT PRIMARY KEY,
+	data BYTEA NOT NULL,
+	size BIGINT NOT NULL,
+	expires BIGINT NOT NULL,
+	used BIGINT NOT NULL
+);
+CREATE TABLE cache_stats (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	hits BIGINT NOT NULL,
+	stale BIGINT NOT NULL,
+	misses BIGINT NOT NULL
+);
+CREATE TABLE host_stats (
+	host TEXT PRIMARY KEY,
+	fetches BIGINT NOT NULL,
+	errors BIGINT NOT NULL,
+	took BIGINT NOT NULL
+);
+`}
+
+// openDatabase opens the database in dir, creating what's missing.
+func openDatabase(dir string) (*stateDB, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(dir, "rerss.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	return migrated(&stateDB{DB: db})
+}
+
+// openPostgres opens the Postgres database at the URL, creating what's
+// missing. Several instances can share it.
+func openPostgres(databaseURL string) (*stateDB, error) {
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return migrated(&stateDB{DB: db, postgres: true})
+}
+
+func migrated(db *stateDB) (*stateDB, error) {
+	if err := db.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating the database: %w", err)
+	}
+	return db, nil
+}
+
+// migrate brings the database to the last version. SQLite keeps the version
+// it's at in its user_version, Postgres in schema_version, where instances
+// starting at once take turns.
+func (db *stateDB) migrate() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var version int
+	versions := migrations
+	if db.postgres {
+		versions = postgresMigrations
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(7367288)`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+			return err
+		}
+		err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	} else {
+		err = tx.QueryRow(`PRAGMA user_version`).Scan(&version)
+	}
+	if err != nil {
+		return err
+	}
+	if version >= len(versions) {
+		return nil
+	}
+	for _, migration := range versions[version:] {
+		if _, err := tx.Exec(migration); err != nil {
+			return err
+		}
+	}
+	if db.postgres {
+		_, err = tx.Exec(`DELETE FROM schema_version; INSERT INTO schema_version VALUES (` + strconv.Itoa(len(versions)) + `)`)
+	} else {
+		_, err = tx.Exec(`PRAGMA user_version = ` + strconv.Itoa(len(versions)))
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// savedFeed is the saved feed of the user at the slug, from the config or
+// else the database.
+func savedFeed(user, slug string) (params, bool, error) {
+	if feed, found := cfg().feeds(user)[slug]; found {
+		return feed, true, nil
+	}
+	if database == nil {
+		return nil, false, nil
+	}
+	var data string
+	err := database.QueryRow(`SELECT params FROM feeds WHERE owner = ? AND slug = ?`, user, slug).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var feed params
+	if err := json.Unmarshal([]byte(data), &feed); err != nil {
+		return nil, false, err
+	}
+	return feed, true, nil
+}
+
+// storedFeeds are the feeds of the user saved in the database, by slug.
+func storedFeeds(user string) (map[string]params, error) {
+	rows, err := database.Query(`SELECT slug, params FROM feeds WHERE owner = ?`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	feeds := map[string]params{}
+	for rows.Next() {
+		var slug, data string
+		if err := rows.Scan(&slug, &data); err != nil {
+			return nil, err
+		}
+		var feed params
+		if err := json.Unmarshal([]byte(data), &feed); err != nil {
+			return nil, err
+		}
+		feeds[slug] = feed
+	}
+	return feeds, rows.Err()
+}
+
+func storeFeed(user, slug string, feed params) error {
+	data, err := json.Marshal(feed)
+	if err != nil {
+		return err
+	}
+	_, err = database.Exec(`INSERT INTO feeds (owner, slug, params) VALUES (?, ?, ?)
+		ON CONFLICT (owner, slug) DO UPDATE SET params = excluded.params`, user, slug, string(data))
+	return err
+}
+
+// storeCredentials keeps the encrypted credentials of the feed, nil removes
+// them.
+func storeCredentials(user, slug string, encrypted []byte) error {
+	_, err := database.Exec(`UPDATE feeds SET credentials = ? WHERE owner = ? AND slug = ?`, encrypted, user, slug)
+	return err
+}
+
+// storedCredentials are the encrypted credentials of the feed, nil if it has
+// none.
+func storedCredentials(user, slug string) ([]byte, error) {
+	var encrypted []byte
+	err := database.QueryRow(`SELECT credentials FROM feeds WHERE owner = ? AND slug = ?`, user, slug).Scan(&encrypted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return encrypted, err
+}
+
+// feedsWithCredentials are the slugs of the user's feeds with credentials.
+func feedsWithCredentials(user string) (map[string]bool, error) {
+	rows, err := database.Query(`SELECT slug FROM feeds WHERE owner = ? AND credentials IS NOT NULL`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	slugs := map[string]bool{}
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs[slug] = true
+	}
+	return slugs, rows.Err()
+}
+
+// deleteStoredFeed deletes the feed, it's false if there was none.
+func deleteStoredFeed(user, slug string) (bool, error) {
+	result, err := database.Exec(`DELETE FROM feeds WHERE owner = ? AND slug = ?`, user, slug)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// diskCache keeps the responses in files too, so a restart starts with them
+// instead of fetching every feed at once. Past maxSize the least recently
+// used ones are removed.
+type diskCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+}
+
+func newDiskCache(dir string, maxSize int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir, maxSize: maxSize}, nil
+}
+
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get reads the response, marking it used.
+func (c *diskCache) get(key string) (*cachedResponse, bool) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	response, err := decodeResponse(data)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	if now.After(response.expires.Add(staleRetention)) {
+		os.Remove(path)
+		return nil, false
+	}
+	os.Chtimes(path, now, now)
+	return response, true
+}
+
+// set writes the response, then removes the ones unused for staleRetention
+// and the least recently used while they take more than maxSize.
+func (c *diskCache) set(key string, response *cachedResponse) {
+	data, err := encodeResponse(response)
+	if err != nil {
+		log.Printf("caching on disk: %v", err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(key)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		log.Printf("caching on disk: %v", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("caching on disk: %v", err)
+		return
+	}
+	c.evict()
+}
+
+func (c *diskCache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("evicting from disk: %v", err)
+		return
+	}
+	type cachedFile struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cachedFile
+	var size int64
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+		if time.Since(info.ModTime()) > staleRetention {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, cachedFile{path, info.Size(), info.ModTime()})
+		size += info.Size()
+	}
+	slices.SortFunc(files, func(a, b cachedFile) int { return a.used.Compare(b.used) })
+	for _, file := range files {
+		if size <= c.maxSize {
+			break
+		}
+		os.Remove(file.path)
+		size -= file.size
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"github.com/mmcdole/gofeed"
+)
+
+// exprCostLimit keeps a single expression from hogging the server, it's well
+// above what any reasonable filter needs.
+const exprCostLimit = 100_000
+
+var exprEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		ext.Strings(),
+		cel.Variable("title", cel.StringType),
+		cel.Variable("link", cel.StringType),
+		cel.Variable("description", cel.StringType),
+		cel.Variable("content", cel.StringType),
+		cel.Variable("author", cel.StringType),
+		cel.Variable("categories", cel.ListType(cel.StringType)),
+		cel.Variable("published", cel.TimestampType),
+		cel.Variable("now", cel.TimestampType),
+	)
+})
+
+// compileExpr turns a CEL expression over the item's fields into a filter.
+func compileExpr(source string) (keepFunc, error) {
+	env, err := exprEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("invalid 'q': %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("invalid 'q': must be a condition, not %s", ast.OutputType())
+	}
+	program, err := env.Program(ast, cel.CostLimit(exprCostLimit))
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'q': %w", err)
+	}
+
+	return func(item *gofeed.Item) bool {
+		out, _, err := program.Eval(exprVars(item))
+		if err != nil {
+			return false
+		}
+		keep, _ := out.Value().(bool)
+		return keep
+	}, nil
+}
+
+func exprVars(item *gofeed.Item) map[string]any {
+	vars := map[string]any{
+		"title":       item.Title,
+		"link":        item.Link,
+		"description": item.Description,
+		"content":     item.Content,
+		"author":      "",
+		"categories":  item.Categories,
+		"published":   time.Time{},
+		"now":         time.Now(),
+	}
+	if item.Categories == nil {
+		vars["categories"] = []string{}
+	}
+	if item.Author != nil {
+		vars["author"] = item.Author.Name
+	}
+	if date := itemDate(item); date != nil {
+		vars["published"] = *date
+	}
+	return vars
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/xml"
+	"maps"
+	"slices"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// extensionNamespaces are the namespaces whose elements are copied to the
+// output as they were, by the prefix gofeed files them under.
+var extensionNamespaces = map[string]string{
+	"itunes":     "http://www.itunes.com/dtds/podcast-1.0.dtd",
+	"googleplay": "http://www.google.com/schemas/play-podcasts/1.0",
+	"podcast":    "https://podcastindex.org/namespace/1.0",
+	"media":      "http://search.yahoo.com/mrss/",
+}
+
+// skippedExtensions would point podcast apps away from the filtered feed.
+var skippedExtensions = map[string]bool{"itunes:new-feed-url": true}
+
+// xmlExtension is an element gofeed parsed into its generic extension map,
+// written back out with its prefix.
+type xmlExtension struct {
+	prefix string
+	ext.Extension
+}
+
+func (e xmlExtension) MarshalXML(encoder *xml.Encoder, _ xml.StartElement) error {
+	start := xml.StartElement{Name: xml.Name{Local: e.prefix + ":" + e.Name}}
+	for _, name := range slices.Sorted(maps.Keys(e.Attrs)) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: name}, Value: e.Attrs[name]})
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	if e.Value != "" {
+		if err := encoder.EncodeToken(xml.CharData(e.Value)); err != nil {
+			return err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(e.Children)) {
+		for _, child := range e.Children[name] {
+			if err := encoder.Encode(xmlExtension{e.prefix, child}); err != nil {
+				return err
+			}
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// copyExtensions picks the elements in the copied namespaces, used collects
+// the prefixes that have to be declared.
+func copyExtensions(extensions ext.Extensions, used map[string]bool) []xmlExtension {
+	var copied []xmlExtension
+	for _, prefix := range slices.Sorted(maps.Keys(extensions)) {
+		if _, known := extensionNamespaces[prefix]; !known {
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(extensions[prefix])) {
+			if skippedExtensions[prefix+":"+name] {
+				continue
+			}
+			for _, extension := range extensions[prefix][name] {
+				copied = append(copied, xmlExtension{prefix, extension})
+				used[prefix] = true
+			}
+		}
+	}
+	return copied
+}
+
+func namespaceAttrs(used map[string]bool) []xml.Attr {
+	var attrs []xml.Attr
+	for _, prefix := range slices.Sorted(maps.Keys(used)) {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "xmlns:" + prefix}, Value: extensionNamespaces[prefix]})
+	}
+	return attrs
+}
+
+// imageExtension gives the item's image as media:thumbnail, unless the copied
+// elements already carry an image for it.
+func imageExtension(item *gofeed.Item, copied []xmlExtension, used map[string]bool) []xmlExtension {
+	if item.Image == nil || item.Image.URL == "" {
+		return copied
+	}
+	hasImage := slices.ContainsFunc(copied, func(e xmlExtension) bool {
+		return e.prefix == "media" || (e.prefix == "itunes" && e.Name == "image")
+	})
+	if hasImage {
+		return copied
+	}
+	used["media"] = true
+	return append(copied, xmlExtension{"media", ext.Extension{Name: "thumbnail", Attrs: map[string]string{"url": item.Image.URL}}})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// failureBackoff is how long a failing upstream is left alone after its
+	// first failure, doubling with every next one up to maxFailureBackoff.
+	failureBackoff    = 30 * time.Second
+	maxFailureBackoff = time.Hour
+)
+
+// failureTracker remembers the upstream pages that failed to fetch, so they
+// aren't fetched again on every poll until they've been left alone for a
+// while. The more times in a row a page fails, the longer that is.
+type failureTracker struct {
+	mu    sync.Mutex
+	pages map[string]*pageFailure
+}
+
+type pageFailure struct {
+	count int
+	err   error
+	until time.Time
+}
+
+var upstreamFailures = &failureTracker{pages: map[string]*pageFailure{}}
+
+// check gives the error the page last failed with while it's left alone.
+func (t *failureTracker) check(pageURL string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	failure, found := t.pages[pageURL]
+	if !found || time.Now().After(failure.until) {
+		return nil
+	}
+	return fmt.Errorf("%s failed %d in a row, not trying again until %s: %w",
+		pageURL, failure.count, failure.until.Format(time.TimeOnly), failure.err)
+}
+
+// record counts the fetch of the page, a success forgets its failures.
+func (t *failureTracker) record(pageURL string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for failed, failure := range t.pages {
+		if now.Sub(failure.until) > maxFailureBackoff {
+			delete(t.pages, failed)
+		}
+	}
+	if err == nil {
+		delete(t.pages, pageURL)
+		return
+	}
+	failure, found := t.pages[pageURL]
+	if !found {
+		failure = &pageFailure{}
+		t.pages[pageURL] = failure
+	}
+	failure.count++
+	failure.err = err
+	// 30s<<7 is past maxFailureBackoff already, shifting further would overflow
+	failure.until = now.Add(min(failureBackoff<<min(failure.count-1, 7), maxFailureBackoff))
+}
+
+// status is the failing pages for the status page. Unless full it's only
+// their hosts, the pages the longest failing of each, without the errors,
+// which have the pages in them.
+func (t *failureTracker) status(full bool) map[string]failingStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	failing := map[string]failingStatus{}
+	for pageURL, failure := range t.pages {
+		if full {
+			failing[pageURL] = failingStatus{InARow: failure.count, Error: failure.err.Error(), NextTry: failure.until}
+			continue
+		}
+		host := "?"
+		if u, err := url.Parse(pageURL); err == nil {
+			host = u.Host
+		}
+		if failure.count > failing[host].InARow {
+			failing[host] = failingStatus{InARow: failure.count, NextTry: failure.until}
+		}
+	}
+	return failing
+}
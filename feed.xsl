@@ -0,0 +1,36 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<xsl:stylesheet version="1.0"
+    xmlns:xsl="http://www.w3.org/1999/XSL/Transform"
+    xmlns:atom="http://www.w3.org/2005/Atom">
+    <xsl:output method="html" encoding="UTF-8" />
+    <xsl:template match="/">
+        <html>
+            <head>
+                <meta name="viewport" content="width=device-width, initial-scale=1" />
+                <title><xsl:value-of select="rss/channel/title | atom:feed/atom:title" /></title>
+            </head>
+            <body>
+                <p><small>This is a feed, copy its address from the address bar into a feed reader to subscribe.</small></p>
+                <xsl:apply-templates select="rss/channel | atom:feed" />
+            </body>
+        </html>
+    </xsl:template>
+    <xsl:template match="channel">
+        <h2><a href="{link}"><xsl:value-of select="title" /></a></h2>
+        <p><xsl:value-of select="description" /></p>
+        <xsl:for-each select="item">
+            <hr />
+            <h3><a href="{link}"><xsl:value-of select="title" /></a></h3>
+            <p><small><xsl:value-of select="pubDate" /></small></p>
+        </xsl:for-each>
+    </xsl:template>
+    <xsl:template match="atom:feed">
+        <h2><a href="{atom:link[not(@rel) or @rel='alternate']/@href}"><xsl:value-of select="atom:title" /></a></h2>
+        <p><xsl:value-of select="atom:subtitle" /></p>
+        <xsl:for-each select="atom:entry">
+            <hr />
+            <h3><a href="{atom:link[not(@rel) or @rel='alternate']/@href}"><xsl:value-of select="atom:title" /></a></h3>
+            <p><small><xsl:value-of select="atom:updated" /></small></p>
+        </xsl:for-each>
+    </xsl:template>
+</xsl:stylesheet>
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/html"
+	"golang.org/x/sync/singleflight"
+)
+
+// sourceFeeds tells which of the merged feeds each item came from.
+type sourceFeeds map[*gofeed.Item]*gofeed.Feed
+
+// pageParser makes a feed of a fetched page that isn't one.
+type pageParser interface {
+	feed(ctx context.Context, page []byte, pageURL string) (*gofeed.Feed, error)
+}
+
+// siteAdapter reads a site's API instead of the page at the URL, apiURL is
+// where the API has what the page shows.
+type siteAdapter struct {
+	apiURL func(u *url.URL) (string, bool)
+	parser pageParser
+}
+
+var siteAdapters = []siteAdapter{
+	{redditAPIURL, redditParser{}},
+	{hackerNewsAPIURL, hackerNewsParser{}},
+	{youtubeFeedURL, youtubeParser{}},
+	{githubAPIURL, githubParser{}},
+	{blueskyAPIURL, blueskyParser{}},
+	// last, as it takes any /@name page for a Mastodon profile
+	{mastodonAPIURL, mastodonParser{}},
+}
+
+// parseSource reads how to make feeds of the pages at the URLs, it's nil when
+// they're feeds already.
+func parseSource(query url.Values) (pageParser, error) {
+	scrape, err := parseScraper(query)
+	if err != nil {
+		return nil, err
+	}
+	mapper, err := parseJSONMapper(query)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case scrape != nil && mapper != nil:
+		return nil, errors.New("'item' and 'map_' parameters can't be combined")
+	case scrape != nil:
+		return scrape, nil
+	case mapper != nil:
+		return mapper, nil
+	}
+	return nil, nil
+}
+
+// fetchFeeds fetches the feeds at the URLs concurrently. A single feed comes
+// back as is, several are merged into one with their items interleaved
+// newest first and repeated items dropped. Feeds that fail to load are left
+// out of the merge unless all of them do. The fallback at the same position as
+// a URL is tried when its feed fails to load.
+func fetchFeeds(ctx context.Context, urls, fallbacks []string, parser pageParser) (*gofeed.Feed, sourceFeeds, error) {
+	fetched := make([]*gofeed.Feed, len(urls))
+	errs := make([]error, len(urls))
+	var wg sync.WaitGroup
+	for i, feedURL := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fetched[i], errs[i] = fetchFeed(ctx, feedURL, parser)
+			if errs[i] != nil && i < len(fallbacks) && fallbacks[i] != "" {
+				log.Printf("fetching %s: %v, trying %s", feedURL, errs[i], fallbacks[i])
+				warn(ctx, fmt.Sprintf("%s failed to load, %s was used instead: %v", feedURL, fallbacks[i], errs[i]))
+				fetched[i], errs[i] = fetchFeed(ctx, fallbacks[i], parser)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(urls) == 1 {
+		if errs[0] != nil {
+			return nil, nil, errs[0]
+		}
+		return fetched[0], sourcesOf(fetched...), nil
+	}
+
+	var loaded []*gofeed.Feed
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("fetching %s: %v", urls[i], err)
+			warn(ctx, fmt.Sprintf("%s was left out, it failed to load: %v", urls[i], err))
+			continue
+		}
+		loaded = append(loaded, fetched[i])
+	}
+	if len(loaded) == 0 {
+		return nil, nil, fmt.Errorf("fetching feeds: %w", errors.Join(errs...))
+	}
+	return mergeFeeds(loaded), sourcesOf(loaded...), nil
+}
+
+// feedClient fetches the feeds, every fetch is given cfg.FetchTimeout and is
+// canceled along with the request.
+var feedClient = &http.Client{Transport: feedTransport(), CheckRedirect: checkRedirect}
+
+// feedTransport keeps connections to the upstreams open between polls, more
+// of them per host than by default as the same hosts are polled over and over.
+// It speaks HTTP/2 to the hosts that do.
+func feedTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = feedProxy
+	transport.DialContext = dialUpstream
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.MaxIdleConnsPerHost = 16
+	transport.IdleConnTimeout = 2 * time.Minute
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
+
+// errInternalAddress is what fetches from the addresses behind the server fail
+// with.
+var errInternalAddress = errors.New("an internal address")
+
+// dialUpstream connects to the host, but not when it's at a loopback,
+// private or link-local address, so the feeds can't reach what's behind the
+// server. The address is checked once the name is resolved, so the name
+// can't point there either. Hosts allowed_hosts lists by name and the proxies
+// of the config can be at any address.
+func dialUpstream(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if !slices.Contains(cfg().AllowedHosts, host) && !cfg().proxyHost(host) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			ip, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if internalAddr(ip.Addr()) {
+				return fmt.Errorf("%s is at %s, %w", host, ip.Addr(), errInternalAddress)
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// feedProxy picks the proxy of the host's config, then the one of the whole
+// config, then the one of the environment.
+func feedProxy(req *http.Request) (*url.URL, error) {
+	proxy := cfg().Hosts[req.URL.Hostname()].Proxy
+	if proxy == "" {
+		proxy = cfg().Proxy
+	}
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment(req)
+	case "direct":
+		return nil, nil
+	}
+	return url.Parse(proxy)
+}
+
+// fetchFeed fetches the feed or sitemap at the URL, or the feed the page at
+// the URL links to. With a parser the page is made into a feed instead, and
+// so are the pages of the sites with an adapter.
+func fetchFeed(ctx context.Context, feedURL string, parser pageParser) (*gofeed.Feed, error) {
+	if parser != nil {
+		return fetchParsed(ctx, feedURL, parser)
+	}
+	apiURL, parser := adapt(feedURL)
+	feed, err := fetchParsed(ctx, apiURL, parser)
+	if _, guessed := parser.(mastodonParser); guessed && err != nil {
+		log.Printf("looking up %s on Mastodon: %v, fetching the page instead", feedURL, err)
+		return fetchParsed(ctx, feedURL, nil)
+	}
+	return feed, err
+}
+
+// fetchParsed fetches the page at the URL and reads the feed from it.
+func fetchParsed(ctx context.Context, feedURL string, parser pageParser) (*gofeed.Feed, error) {
+	body, err := fetchPage(ctx, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := startSpan(ctx, "parse", attribute.String("url.full", withoutQuery(feedURL)))
+	feed, err := readFeed(ctx, body, feedURL, parser)
+	if err == nil {
+		span.SetAttributes(attribute.Int("rerss.items", len(feed.Items)))
+	}
+	endSpan(span, err)
+	return feed, err
+}
+
+// readFeed makes a feed of the page fetched from the URL, like fetchFeed.
+func readFeed(ctx context.Context, body []byte, feedURL string, parser pageParser) (*gofeed.Feed, error) {
+	if parser != nil {
+		return parser.feed(ctx, body, feedURL)
+	}
+	feed, err := parseFeed(body)
+	if !errors.Is(err, gofeed.ErrFeedTypeNotDetected) {
+		return feed, err
+	}
+	if feed, ok := parseSitemap(body, feedURL); ok {
+		return feed, nil
+	}
+
+	discovered := discoverFeed(body, feedURL)
+	if discovered == "" {
+		return nil, fmt.Errorf("%s is not a feed and doesn't link to one", feedURL)
+	}
+	warn(ctx, fmt.Sprintf("%s is not a feed, the one it links to at %s was used", feedURL, discovered))
+	discovered, parser = adapt(discovered)
+	if body, err = fetchPage(ctx, discovered); err != nil {
+		return nil, err
+	}
+	if parser != nil {
+		return parser.feed(ctx, body, discovered)
+	}
+	return parseFeed(body)
+}
+
+// adapt gives where a site's adapter reads what's at the URL from and how,
+// the parser is nil if the site has none or the adapter only moves the URL.
+func adapt(pageURL string) (string, pageParser) {
+	parsed, err := url.Parse(pageURL)
+	if err != nil {
+		return pageURL, nil
+	}
+	for _, adapter := range siteAdapters {
+		if apiURL, ok := adapter.apiURL(parsed); ok {
+			return apiURL, adapter.parser
+		}
+	}
+	return pageURL, nil
+}
+
+// requestHeadersKey holds the headers the request asked the fetches to be
+// made with in its context.
+type requestHeadersKey struct{}
+
+// parseRequestHeaders reads the headers to fetch with, ua sets the
+// User-Agent and each header is a "Name: value".
+func parseRequestHeaders(query url.Values) (http.Header, error) {
+	headers := http.Header{}
+	for _, header := range query["header"] {
+		name, value, found := strings.Cut(header, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("'header' must be like 'Name: value', not %q", header)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if query.Has("ua") {
+		headers.Set("User-Agent", query.Get("ua"))
+	}
+	return headers, nil
+}
+
+// defaultUserAgent is what fetches are made as, unless the config, the host's
+// config or the request say otherwise.
+const defaultUserAgent = "rerss"
+
+// maxRetryWait is the longest a host can ask fetchPageRetrying to wait with
+// Retry-After, when it asks for longer, or for longer than cfg.FetchTimeout,
+// it's not retried.
+const maxRetryWait = 30 * time.Second
+
+// pageFetches collapses concurrent fetches of the same page into one.
+var pageFetches singleflight.Group
+
+// fetchPage fetches the page, sharing the fetch with the other requests
+// fetching it at the same time with the same headers and credentials. The
+// shared fetch isn't canceled with any one of them. A page that keeps failing
+// isn't fetched for a while, failing right away instead.
+func fetchPage(ctx context.Context, pageURL string) ([]byte, error) {
+	if err := upstreamFailures.check(pageURL); err != nil {
+		return nil, err
+	}
+	fetch := pageFetches.DoChan(pageKey(ctx, pageURL), func() (any, error) {
+		body, err := fetchPageRetrying(context.WithoutCancel(ctx), pageURL)
+		upstreamFailures.record(pageURL, err)
+		return body, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-fetch:
+		body, _ := result.Val.([]byte)
+		return body, result.Err
+	}
+}
+
+// pageKey tells the fetches of the page made with different headers or
+// credentials apart, without keeping the credentials.
+func pageKey(ctx context.Context, pageURL string) string {
+	var sent strings.Builder
+	if headers, ok := ctx.Value(requestHeadersKey{}).(http.Header); ok {
+		headers.Write(&sent)
+	}
+	credentialsFor(ctx, pageURL).Write(&sent)
+	if sent.Len() == 0 {
+		return pageURL
+	}
+	sum := sha256.Sum256([]byte(sent.String()))
+	return pageURL + "\n" + hex.EncodeToString(sum[:])
+}
+
+// fetchPageRetrying fetches the page, retrying failures that might not happen
+// again with exponential backoff, up to cfg.FetchTimeout between tries, or
+// when the host says with Retry-After.
+func fetchPageRetrying(ctx context.Context, pageURL string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := fetchPageOnce(ctx, pageURL)
+		if err == nil || retryAfter < 0 || attempt >= cfg().Retries {
+			return body, err
+		}
+		if retryAfter > min(maxRetryWait, cfg().FetchTimeout) {
+			return nil, err
+		}
+		// the shift is capped so many retries can't overflow it
+		wait := min(max(retryAfter, cfg().RetryBackoff<<min(attempt, 16)), cfg().FetchTimeout)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// fetchPageOnce makes one attempt at fetching the page. When it fails,
+// retryAfter is how long to wait before trying again, or negative if it's
+// no use.
+func fetchPageOnce(ctx context.Context, pageURL string) (body []byte, retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg().FetchTimeout)
+	defer cancel()
+	slots := cfg().fetchSlots
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		return nil, -1, fmt.Errorf("waiting to fetch %s: %w", pageURL, ctx.Err())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	if !allowedHost(req.URL.Hostname()) {
+		return nil, -1, fmt.Errorf("%s isn't an allowed host", req.URL.Hostname())
+	}
+	req.Header.Set("User-Agent", cfg().UserAgent)
+	host, hasConfig := cfg().Hosts[req.URL.Hostname()]
+	if hasConfig {
+		for name, value := range host.Headers {
+			req.Header.Set(name, value)
+		}
+	}
+	if headers, ok := ctx.Value(requestHeadersKey{}).(http.Header); ok {
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+	}
+	if hasConfig {
+		if host.Username != "" {
+			req.SetBasicAuth(host.Username, host.Password)
+		}
+		for _, name := range slices.Sorted(maps.Keys(host.Cookies)) {
+			req.AddCookie(&http.Cookie{Name: name, Value: host.Cookies[name]})
+		}
+	}
+	for name, values := range credentialsFor(ctx, pageURL) {
+		req.Header[name] = values
+	}
+	key := pageKey(ctx, pageURL)
+	upstreamPages.makeConditional(req, key)
+	started := time.Now()
+	ctx, span := startSpan(ctx, "fetch", attribute.String("url.full", withoutQuery(pageURL)), attribute.String("server.address", req.URL.Host))
+	req = req.WithContext(ctx)
+	defer func() {
+		endSpan(span, err)
+		took := time.Since(started)
+		stats.fetched(pageURL, took, err)
+		if err != nil {
+			slog.Warn("fetch failed", "url", withoutQuery(pageURL), "took", took, "error", err)
+		} else {
+			slog.Debug("fetched", "url", withoutQuery(pageURL), "took", took)
+		}
+	}()
+	resp, err := feedClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, errInternalAddress) {
+			return nil, -1, err
+		}
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode == http.StatusNotModified {
+		if body := upstreamPages.notModified(key); body != nil {
+			return body, 0, nil
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return nil, -1, err
+		}
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), err
+	}
+	tooLarge := fmt.Errorf("%s is larger than %d bytes", pageURL, cfg().MaxPageSize)
+	if resp.ContentLength > cfg().MaxPageSize {
+		return nil, -1, tooLarge
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, cfg().MaxPageSize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(body)) > cfg().MaxPageSize {
+		return nil, -1, tooLarge
+	}
+	upstreamPages.store(key, resp, body)
+	return body, 0, nil
+}
+
+// parseRetryAfter reads Retry-After as seconds or a date, it's 0 if there's
+// none or the date has passed.
+func parseRetryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// discoveryTypes are the feed types a page can link to with
+// <link rel="alternate">, in the order they're preferred.
+var discoveryTypes = []string{"application/rss+xml", "application/atom+xml", "application/feed+json", "application/json"}
+
+// discoverFeed finds the address of the feed the HTML page links to, or ""
+// if it doesn't link to one.
+func discoverFeed(page []byte, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	found := map[string]string{}
+	tokenizer := html.NewTokenizer(bytes.NewReader(page))
+	for done := false; !done; {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data != "link" {
+				continue
+			}
+			var rel, typ, href string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = strings.ToLower(attr.Val)
+				case "type":
+					typ = strings.ToLower(strings.TrimSpace(attr.Val))
+				case "href":
+					href = attr.Val
+				}
+			}
+			if slices.Contains(strings.Fields(rel), "alternate") && href != "" && found[typ] == "" {
+				found[typ] = href
+			}
+		}
+	}
+	for _, typ := range discoveryTypes {
+		if href, ok := found[typ]; ok {
+			if link, err := base.Parse(href); err == nil {
+				return link.String()
+			}
+		}
+	}
+	return ""
+}
+
+// setSiteValue keeps what a site's API says about an item for the filters,
+// it's not written out.
+func setSiteValue(item *gofeed.Item, site, name, value string) {
+	if item.Extensions == nil {
+		item.Extensions = ext.Extensions{}
+	}
+	if item.Extensions[site] == nil {
+		item.Extensions[site] = map[string][]ext.Extension{}
+	}
+	item.Extensions[site][name] = []ext.Extension{{Name: name, Value: value}}
+}
+
+// siteValue is what setSiteValue kept, ok is false for items from elsewhere.
+func siteValue(item *gofeed.Item, site, name string) (value string, ok bool) {
+	values := item.Extensions[site][name]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0].Value, true
+}
+
+func sourcesOf(feeds ...*gofeed.Feed) sourceFeeds {
+	sources := sourceFeeds{}
+	for _, feed := range feeds {
+		for _, item := range feed.Items {
+			sources[item] = feed
+		}
+	}
+	return sources
+}
+
+// mergeFeeds makes one feed of the feeds, it's in the format of the first.
+func mergeFeeds(feeds []*gofeed.Feed) *gofeed.Feed {
+	var titles []string
+	for _, feed := range feeds {
+		titles = append(titles, feed.Title)
+	}
+	merged := &gofeed.Feed{
+		Title:       strings.Join(titles, ", "),
+		Description: "Merged from " + strings.Join(titles, ", "),
+		FeedType:    feeds[0].FeedType,
+		FeedVersion: feeds[0].FeedVersion,
+	}
+
+	ids := map[string]bool{}
+	for _, feed := range feeds {
+		for _, item := range feed.Items {
+			if id := itemID(item); !ids[id] {
+				ids[id] = true
+				merged.Items = append(merged.Items, item)
+			}
+		}
+	}
+	slices.SortStableFunc(merged.Items, func(a, b *gofeed.Item) int { return compareDates(b, a) })
+	return merged
+}
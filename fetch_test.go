@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig is the default config, changed by change.
+func testConfig(t *testing.T, change func(conf *config)) {
+	t.Helper()
+	conf, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.AllowedHosts = []string{"127.0.0.1", "localhost"}
+	change(conf)
+	conf.fetchSlots = make(chan struct{}, conf.MaxFetches)
+	withConfig(t, conf)
+}
+
+// redirectingServers are a server that redirects to another host, the
+// headers each of them got and the URL to fetch.
+func redirectingServers(t *testing.T) (first, second *http.Header, pageURL string) {
+	first, second = &http.Header{}, &http.Header{}
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*second = r.Header.Clone()
+		w.Write([]byte("<rss/>"))
+	}))
+	t.Cleanup(target.Close)
+	elsewhere := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	redirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*first = r.Header.Clone()
+		http.Redirect(w, r, elsewhere+"/feed.xml", http.StatusFound)
+	}))
+	t.Cleanup(redirecting.Close)
+	return first, second, redirecting.URL + "/feed.xml"
+}
+
+func TestRedirectToAnotherHost(t *testing.T) {
+	testConfig(t, func(conf *config) {
+		conf.Hosts = map[string]hostConfig{"127.0.0.1": {
+			Username: "user",
+			Password: "pass",
+			Headers:  map[string]string{"X-Token": "secret"},
+			Cookies:  map[string]string{"session": "secret"},
+		}}
+	})
+	first, second, pageURL := redirectingServers(t)
+	if _, err := fetchPage(context.Background(), pageURL); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"X-Token", "Authorization", "Cookie"} {
+		if first.Get(name) == "" {
+			t.Errorf("the host wasn't sent its %s", name)
+		}
+		if second.Get(name) != "" {
+			t.Errorf("%s went along with the redirect", name)
+		}
+	}
+}
+
+func TestCredentialsNotRedirected(t *testing.T) {
+	testConfig(t, func(conf *config) {})
+	first, second, pageURL := redirectingServers(t)
+	ctx := withCredentials(context.Background(), params{"url": {pageURL}}, &feedCredentials{
+		Username: "user",
+		Headers:  map[string]string{"X-Token": "secret"},
+	})
+	if _, err := fetchPage(ctx, pageURL); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"X-Token", "Authorization"} {
+		if first.Get(name) == "" {
+			t.Errorf("the feed's URL wasn't sent its %s", name)
+		}
+		if second.Get(name) != "" {
+			t.Errorf("%s went along with the redirect", name)
+		}
+	}
+}
+
+// TestPagesKeptByCredentials checks that a page fetched with credentials isn't
+// what a fetch without them gets.
+func TestPagesKeptByCredentials(t *testing.T) {
+	testConfig(t, func(conf *config) {})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"private"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Header.Get("Authorization") == "" {
+			w.Write([]byte("public"))
+			return
+		}
+		w.Header().Set("ETag", `"private"`)
+		w.Write([]byte("private"))
+	}))
+	t.Cleanup(server.Close)
+	pageURL := server.URL + "/feed.xml"
+	ctx := withCredentials(context.Background(), params{"url": {pageURL}}, &feedCredentials{Username: "user"})
+
+	for _, test := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{ctx, "private"},
+		{ctx, "private"},
+		{context.Background(), "public"},
+	} {
+		body, err := fetchPage(test.ctx, pageURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != test.want {
+			t.Errorf("got %q, want %q", body, test.want)
+		}
+	}
+}
+
+func TestInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<rss/>"))
+	}))
+	t.Cleanup(server.Close)
+	byName := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	for _, test := range []struct {
+		name         string
+		allowedHosts []string
+		pageURL      string
+		want         bool
+	}{
+		{"any host", nil, server.URL, false},
+		{"by name", nil, byName, false},
+		{"allowed by address", []string{"127.0.0.1"}, server.URL, true},
+		{"allowed by name", []string{"localhost"}, byName, true},
+		{"allowed as a subdomain", []string{"0.1"}, server.URL, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testConfig(t, func(conf *config) {
+				conf.AllowedHosts = test.allowedHosts
+				conf.Retries = 0
+			})
+			// each page, as the failed ones aren't fetched again for a while
+			feedClient.CloseIdleConnections()
+			_, err := fetchPage(context.Background(), test.pageURL+"/"+url.PathEscape(test.name))
+			if test.want && err != nil {
+				t.Errorf("not fetched: %v", err)
+			}
+			if !test.want && !errors.Is(err, errInternalAddress) {
+				t.Errorf("got %v, want %v", err, errInternalAddress)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-5", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		t.Run(test.header, func(t *testing.T) {
+			if got := parseRetryAfter(test.header); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got <= 0 || got > time.Minute {
+		t.Errorf("got %v for a minute from now", got)
+	}
+}
+
+// TestRetryAfterPassed checks that a host asking to retry at a time that has
+// already passed is retried right away.
+func TestRetryAfterPassed(t *testing.T) {
+	testConfig(t, func(conf *config) {
+		conf.Retries = 40
+		conf.RetryBackoff = time.Millisecond
+		conf.FetchTimeout = 10 * time.Millisecond
+	})
+	tries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tries++; tries < 20 {
+			w.Header().Set("Retry-After", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("<rss/>"))
+	}))
+	t.Cleanup(server.Close)
+	if _, err := fetchPage(context.Background(), server.URL+"/feed.xml"); err != nil {
+		t.Fatalf("gave up after %d tries: %v", tries, err)
+	}
+}
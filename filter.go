@@ -0,0 +1,591 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/abadojack/whatlanggo"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+type keepFunc func(item *gofeed.Item) bool
+
+// filterRule is one of the filters of a query, an item has to pass all of
+// them.
+type filterRule struct {
+	// params are the parameters the rule is made of, like skip=AI&skip=OpenAI
+	params string
+	keep   keepFunc
+}
+
+func parseFilter(query url.Values) (keepFunc, error) {
+	rules, err := parseRules(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("missing a filter, e.g. 're', 'keep' or 'skip'")
+	}
+	return func(item *gofeed.Item) bool {
+		for _, rule := range rules {
+			if !rule.keep(item) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// parseRules makes the rules the filters of the query are, none without any.
+func parseRules(query url.Values) ([]filterRule, error) {
+	logic := query.Get("logic")
+	if logic != "" && logic != "and" && logic != "or" {
+		return nil, errors.New("'logic' must be 'and' or 'or'")
+	}
+
+	ignoreCase, wholeWord := query.Get("i") == "1", query.Get("word") == "1"
+
+	var rules []filterRule
+	add := func(params string, keep keepFunc) { rules = append(rules, filterRule{params, keep}) }
+	// rule is how the params look in the query, the ones it doesn't have left out
+	rule := func(params ...string) string {
+		var pairs []string
+		for _, param := range params {
+			for _, value := range query[param] {
+				pairs = append(pairs, param+"="+value)
+			}
+		}
+		return strings.Join(pairs, "&")
+	}
+	if patterns, specified := query["re"]; specified {
+		regexes, err := compileAll(patterns, ignoreCase, wholeWord)
+		if err != nil {
+			return nil, err
+		}
+		if logic == "or" {
+			add(rule("re", "logic"), func(item *gofeed.Item) bool { return anyMatch(regexes, item.Title) })
+		} else {
+			add(rule("re", "logic"), func(item *gofeed.Item) bool { return allMatch(regexes, item.Title) })
+		}
+	}
+	if patterns, specified := query["xre"]; specified {
+		regexes, err := compileAll(patterns, ignoreCase, wholeWord)
+		if err != nil {
+			return nil, err
+		}
+		add(rule("xre"), func(item *gofeed.Item) bool { return !anyMatch(regexes, item.Title) })
+	}
+	exact := query.Get("exact") == "1"
+	if words, specified := query["keep"]; specified {
+		add(rule("keep"), func(item *gofeed.Item) bool { return containsPhrase(item.Title, words, exact) })
+	}
+	if skips, specified := query["skip"]; specified {
+		add(rule("skip"), func(item *gofeed.Item) bool { return !containsPhrase(item.Title, skips, exact) })
+	}
+	for _, listURL := range query["blocklist"] {
+		list, err := getBlocklist(listURL)
+		if err != nil {
+			return nil, err
+		}
+		add("blocklist="+listURL, func(item *gofeed.Item) bool { return !list.matches(item.Title, exact) })
+	}
+	if patterns, specified := query["link_re"]; specified {
+		regexes, err := compileAll(patterns, ignoreCase, false)
+		if err != nil {
+			return nil, err
+		}
+		add(rule("link_re"), func(item *gofeed.Item) bool { return anyMatch(regexes, item.Link) })
+	}
+	if domains, specified := query["domain"]; specified {
+		add(rule("domain"), func(item *gofeed.Item) bool { return inDomain(item.Link, domains) })
+	}
+	if domains, specified := query["xdomain"]; specified {
+		add(rule("xdomain"), func(item *gofeed.Item) bool { return !inDomain(item.Link, domains) })
+	}
+	if cats, specified := query["cat"]; specified {
+		add(rule("cat"), func(item *gofeed.Item) bool { return hasCategory(item, cats) })
+	}
+	if cats, specified := query["xcat"]; specified {
+		add(rule("xcat"), func(item *gofeed.Item) bool { return !hasCategory(item, cats) })
+	}
+	if query.Has("has_enclosure") {
+		want := query.Get("has_enclosure") == "1"
+		add(rule("has_enclosure"), func(item *gofeed.Item) bool { return (len(item.Enclosures) > 0) == want })
+	}
+	if types, specified := query["enclosure_type"]; specified {
+		for _, pattern := range types {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid 'enclosure_type' %q: %w", pattern, err)
+			}
+		}
+		add(rule("enclosure_type"), func(item *gofeed.Item) bool { return hasEnclosureType(item, types) })
+	}
+	if authors, specified := query["author"]; specified {
+		add(rule("author"), func(item *gofeed.Item) bool { return hasAuthor(item, authors) })
+	}
+	if authors, specified := query["xauthor"]; specified {
+		add(rule("xauthor"), func(item *gofeed.Item) bool { return !hasAuthor(item, authors) })
+	}
+	for _, param := range []string{"since", "until"} {
+		if !query.Has(param) {
+			continue
+		}
+		bound, err := parseTime(query.Get(param), time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s': %w", param, err)
+		}
+		isAfterBound := param == "since"
+		add(rule(param), func(item *gofeed.Item) bool {
+			date := itemDate(item)
+			return date == nil || date.After(bound) == isAfterBound
+		})
+	}
+	if query.Has("max_age") {
+		maxAge, err := parseDuration(query.Get("max_age"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'max_age': %w", err)
+		}
+		oldest := time.Now().Add(-maxAge)
+		add(rule("max_age"), func(item *gofeed.Item) bool {
+			date := itemDate(item)
+			return date == nil || date.After(oldest)
+		})
+	}
+	for _, param := range []string{"min_words", "min_chars"} {
+		if !query.Has(param) {
+			continue
+		}
+		minimum, err := strconv.Atoi(query.Get(param))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' must be a number", param)
+		}
+		countWords := param == "min_words"
+		add(rule(param), func(item *gofeed.Item) bool {
+			text := itemText(item)
+			if countWords {
+				return len(strings.Fields(text)) >= minimum
+			}
+			return utf8.RuneCountInString(text) >= minimum
+		})
+	}
+	if langs, specified := query["lang"]; specified {
+		add(rule("lang"), func(item *gofeed.Item) bool {
+			lang, known := detectLanguage(item)
+			return !known || isLanguage(lang, langs)
+		})
+	}
+	if langs, specified := query["xlang"]; specified {
+		add(rule("xlang"), func(item *gofeed.Item) bool {
+			lang, known := detectLanguage(item)
+			return !known || !isLanguage(lang, langs)
+		})
+	}
+	if rules, specified := query["score"]; specified {
+		weights, err := parseWeights(rules)
+		if err != nil {
+			return nil, err
+		}
+		minScore := 1
+		if query.Has("min_score") {
+			minScore, err = strconv.Atoi(query.Get("min_score"))
+			if err != nil {
+				return nil, errors.New("'min_score' must be a number")
+			}
+		}
+		add(rule("score", "min_score"), func(item *gofeed.Item) bool { return score(item, weights, exact) >= minScore })
+	}
+	for _, source := range query["q"] {
+		keep, err := compileExpr(source)
+		if err != nil {
+			return nil, err
+		}
+		add("q="+source, keep)
+	}
+	if query.Has("days") || query.Has("hours") {
+		keep, err := parseSchedule(query.Get("days"), query.Get("hours"), query.Get("tz"))
+		if err != nil {
+			return nil, err
+		}
+		add(rule("days", "hours", "tz"), keep)
+	}
+	if query.Has("min_duration") {
+		minDuration, err := parseDuration(query.Get("min_duration"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'min_duration': %w", err)
+		}
+		add(rule("min_duration"), func(item *gofeed.Item) bool {
+			duration, known := itemDuration(item)
+			return !known || duration >= minDuration
+		})
+	}
+	if query.Get("no_shorts") == "1" {
+		add(rule("no_shorts"), func(item *gofeed.Item) bool { return !strings.Contains(item.Link, "youtube.com/shorts/") })
+	}
+	if query.Get("stable_only") == "1" {
+		add(rule("stable_only"), func(item *gofeed.Item) bool {
+			prerelease, found := siteValue(item, "github", "prerelease")
+			if !found && strings.HasPrefix(item.Link, "https://github.com/") {
+				// the tags of GitHub's tags.atom
+				return !unstableTag.MatchString(item.Title)
+			}
+			return prerelease != "true"
+		})
+	}
+	for param, name := range map[string]string{"boosts": "boost", "replies": "reply"} {
+		if query.Get(param) != "0" {
+			continue
+		}
+		add(rule(param), func(item *gofeed.Item) bool {
+			is, _ := siteValue(item, "social", name)
+			return is != "true"
+		})
+	}
+	if query.Has("min_upvotes") {
+		minUpvotes, err := strconv.Atoi(query.Get("min_upvotes"))
+		if err != nil {
+			return nil, errors.New("'min_upvotes' must be a number")
+		}
+		add(rule("min_upvotes"), func(item *gofeed.Item) bool {
+			upvotes, _ := siteValue(item, "reddit", "score")
+			score, err := strconv.Atoi(upvotes)
+			return err == nil && score >= minUpvotes
+		})
+	}
+	for _, param := range []string{"min_points", "min_comments"} {
+		if !query.Has(param) {
+			continue
+		}
+		minimum, err := strconv.Atoi(query.Get(param))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' must be a number", param)
+		}
+		name := strings.TrimPrefix(param, "min_")
+		add(rule(param), func(item *gofeed.Item) bool {
+			value, _ := siteValue(item, "hn", name)
+			count, err := strconv.Atoi(value)
+			return err == nil && count >= minimum
+		})
+	}
+	if flairs, specified := query["flair"]; specified {
+		add(rule("flair"), func(item *gofeed.Item) bool {
+			flair, _ := siteValue(item, "reddit", "flair")
+			return slices.ContainsFunc(flairs, func(want string) bool { return strings.EqualFold(flair, want) })
+		})
+	}
+	if query.Has("post") {
+		kind := query.Get("post")
+		if kind != "self" && kind != "link" {
+			return nil, errors.New("'post' must be 'self' or 'link'")
+		}
+		add(rule("post"), func(item *gofeed.Item) bool {
+			itemKind, _ := siteValue(item, "reddit", "kind")
+			return itemKind == kind
+		})
+	}
+	return rules, nil
+}
+
+func compileAll(patterns []string, ignoreCase, wholeWord bool) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		if wholeWord {
+			pattern = `\b(?:` + pattern + `)\b`
+		}
+		if ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		regexes[i] = regex
+	}
+	return regexes, nil
+}
+
+func anyMatch(regexes []*regexp.Regexp, s string) bool {
+	return slices.ContainsFunc(regexes, func(regex *regexp.Regexp) bool { return regex.MatchString(s) })
+}
+
+func allMatch(regexes []*regexp.Regexp, s string) bool {
+	return !slices.ContainsFunc(regexes, func(regex *regexp.Regexp) bool { return !regex.MatchString(s) })
+}
+
+func hasCategory(item *gofeed.Item, categories []string) bool {
+	return slices.ContainsFunc(item.Categories, func(category string) bool {
+		return slices.ContainsFunc(categories, func(wanted string) bool { return strings.EqualFold(category, wanted) })
+	})
+}
+
+// containsPhrase reports whether the text contains any of the phrases,
+// ignoring case. In exact mode a phrase has to match whole words, ignoring
+// punctuation around them, otherwise any substring does.
+func containsPhrase(text string, phrases []string, exact bool) bool {
+	words := normalizeWords(text, exact)
+	return slices.ContainsFunc(phrases, func(phrase string) bool {
+		phraseWords := normalizeWords(phrase, exact)
+		if len(phraseWords) == 0 {
+			return false
+		}
+		if !exact {
+			return strings.Contains(strings.Join(words, " "), strings.Join(phraseWords, " "))
+		}
+		for i := 0; i+len(phraseWords) <= len(words); i++ {
+			if slices.Equal(words[i:i+len(phraseWords)], phraseWords) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func normalizeWords(s string, trimPunct bool) []string {
+	s = cases.Fold().String(norm.NFC.String(s))
+	words := strings.Fields(s)
+	if trimPunct {
+		for i, word := range words {
+			words[i] = strings.TrimFunc(word, unicode.IsPunct)
+		}
+		words = slices.DeleteFunc(words, func(word string) bool { return word == "" })
+	}
+	return words
+}
+
+// minLanguageConfidence is lower than whatlanggo's own reliability threshold,
+// which rejects most short texts in languages with close relatives, like
+// Russian next to Ukrainian.
+const minLanguageConfidence = 0.1
+
+// detectLanguage guesses the language of the item's title and text, known is
+// false when the guess is too unsure.
+func detectLanguage(item *gofeed.Item) (lang whatlanggo.Lang, known bool) {
+	info := whatlanggo.Detect(item.Title + "\n" + itemText(item))
+	return info.Lang, info.Confidence >= minLanguageConfidence
+}
+
+// isLanguage reports whether lang is one of the ISO 639-1 or 639-3 codes.
+func isLanguage(lang whatlanggo.Lang, codes []string) bool {
+	return slices.ContainsFunc(codes, func(code string) bool {
+		return strings.EqualFold(code, lang.Iso6391()) || strings.EqualFold(code, lang.Iso6393())
+	})
+}
+
+// parseWeights reads keyword weights given as "keyword:weight", separated by
+// commas or in separate parameters.
+func parseWeights(rules []string) (map[string]int, error) {
+	weights := map[string]int{}
+	for _, rule := range rules {
+		for _, pair := range strings.Split(rule, ",") {
+			i := strings.LastIndex(pair, ":")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid 'score' %q: expected keyword:weight", pair)
+			}
+			weight, err := strconv.Atoi(pair[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'score' %q: weight must be a number", pair)
+			}
+			weights[pair[:i]] += weight
+		}
+	}
+	return weights, nil
+}
+
+// score sums the weights of the keywords found in the item's title or
+// description, each keyword counts once.
+func score(item *gofeed.Item, weights map[string]int, exact bool) int {
+	text := item.Title + "\n" + item.Description
+	total := 0
+	for keyword, weight := range weights {
+		if containsPhrase(text, []string{keyword}, exact) {
+			total += weight
+		}
+	}
+	return total
+}
+
+// inDomain reports whether the link points to one of the domains or their
+// subdomains.
+func inDomain(link string, domains []string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return slices.ContainsFunc(domains, func(domain string) bool {
+		domain = strings.ToLower(domain)
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
+
+// hasEnclosureType reports whether any of the item's enclosures has a MIME
+// type matching one of the patterns, like "audio/*".
+func hasEnclosureType(item *gofeed.Item, patterns []string) bool {
+	return slices.ContainsFunc(item.Enclosures, func(enclosure *gofeed.Enclosure) bool {
+		mimeType, _, _ := strings.Cut(strings.ToLower(enclosure.Type), ";")
+		return slices.ContainsFunc(patterns, func(pattern string) bool {
+			matched, _ := path.Match(strings.ToLower(pattern), strings.TrimSpace(mimeType))
+			return matched
+		})
+	})
+}
+
+func hasAuthor(item *gofeed.Item, authors []string) bool {
+	isWanted := func(person *gofeed.Person) bool {
+		return person != nil && slices.ContainsFunc(authors, func(wanted string) bool {
+			return strings.EqualFold(person.Name, wanted) || strings.EqualFold(person.Email, wanted)
+		})
+	}
+	return isWanted(item.Author) || slices.ContainsFunc(item.Authors, isWanted)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseSchedule makes a filter that keeps items published on the days, like
+// "mon-fri" or "sat,sun", and within the hours, like "09-18" or "22-06", in
+// the time zone tz. Empty days or hours mean any day or hour.
+func parseSchedule(days, hours, tz string) (keepFunc, error) {
+	location := time.UTC
+	if tz != "" {
+		var err error
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'tz': %w", err)
+		}
+	}
+
+	var onDay [7]bool
+	if days == "" {
+		onDay = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, span := range strings.Split(days, ",") {
+		if span == "" {
+			continue
+		}
+		firstName, lastName, isRange := strings.Cut(strings.ToLower(span), "-")
+		if !isRange {
+			lastName = firstName
+		}
+		first, firstFound := weekdays[firstName]
+		last, lastFound := weekdays[lastName]
+		if !firstFound || !lastFound {
+			return nil, fmt.Errorf("invalid 'days' %q: expected days like mon-fri or sat,sun", span)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			onDay[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	var inHour [24]bool
+	if hours == "" {
+		for hour := range inHour {
+			inHour[hour] = true
+		}
+	}
+	for _, span := range strings.Split(hours, ",") {
+		if span == "" {
+			continue
+		}
+		startText, endText, _ := strings.Cut(span, "-")
+		start, startErr := strconv.Atoi(startText)
+		end, endErr := strconv.Atoi(endText)
+		if startErr != nil || endErr != nil || start < 0 || start > 23 || end < 0 || end > 24 {
+			return nil, fmt.Errorf("invalid 'hours' %q: expected hours like 09-18", span)
+		}
+		// a span that ends where it starts, like 00-24, is the whole day
+		for hour := start; ; {
+			inHour[hour] = true
+			if hour = (hour + 1) % 24; hour == end%24 {
+				break
+			}
+		}
+	}
+
+	return func(item *gofeed.Item) bool {
+		date := itemDate(item)
+		if date == nil {
+			return true
+		}
+		local := date.In(location)
+		return onDay[local.Weekday()] && inHour[local.Hour()]
+	}, nil
+}
+
+// itemDate returns when the item was published, or last updated if the feed
+// doesn't say, or nil if neither is known.
+func itemDate(item *gofeed.Item) *time.Time {
+	if item.PublishedParsed != nil {
+		return item.PublishedParsed
+	}
+	return item.UpdatedParsed
+}
+
+// parseTime accepts a date, a timestamp or a duration like "7d" that's
+// counted back from now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.DateOnly, time.RFC3339, "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	ago, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date nor a duration", s)
+	}
+	return now.Add(-ago), nil
+}
+
+// parseDuration is time.ParseDuration that also understands days and weeks,
+// e.g. "7d" or "2w".
+func parseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, found := strings.CutSuffix(s, suffix); found {
+			count, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// itemDuration is how long the item's video or episode is, as YouTube or the
+// iTunes tags tell.
+func itemDuration(item *gofeed.Item) (time.Duration, bool) {
+	if seconds, ok := siteValue(item, "youtube", "duration"); ok {
+		n, err := strconv.Atoi(seconds)
+		return time.Duration(n) * time.Second, err == nil
+	}
+	if item.ITunesExt == nil || item.ITunesExt.Duration == "" {
+		return 0, false
+	}
+	// iTunes durations are seconds, or minutes and hours before them
+	var duration time.Duration
+	for _, part := range strings.Split(item.ITunesExt.Duration, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, false
+		}
+		duration = duration*60 + time.Duration(n)
+	}
+	return duration * time.Second, true
+}
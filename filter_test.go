@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestParseScheduleHours(t *testing.T) {
+	for _, test := range []struct {
+		hours         string
+		kept, dropped []int
+	}{
+		{"09-18", []int{9, 12, 17}, []int{8, 18, 23}},
+		{"22-06", []int{22, 23, 0, 5}, []int{6, 12, 21}},
+		{"00-24", []int{0, 9, 12, 23}, nil},
+		{"09-09", []int{0, 8, 9, 23}, nil},
+		{"12-13", []int{12}, []int{11, 13}},
+		{"09-10,14-15", []int{9, 14}, []int{10, 12, 15}},
+		{"", []int{0, 12, 23}, nil},
+	} {
+		t.Run(test.hours, func(t *testing.T) {
+			keep, err := parseSchedule("", test.hours, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			at := func(hour int) *gofeed.Item {
+				published := time.Date(2025, 1, 1, hour, 30, 0, 0, time.UTC)
+				return &gofeed.Item{PublishedParsed: &published}
+			}
+			for _, hour := range test.kept {
+				if !keep(at(hour)) {
+					t.Errorf("dropped an item from %d:30", hour)
+				}
+			}
+			for _, hour := range test.dropped {
+				if keep(at(hour)) {
+					t.Errorf("kept an item from %d:30", hour)
+				}
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalidHours(t *testing.T) {
+	for _, hours := range []string{"9", "24-06", "-1-05", "09-25", "a-b"} {
+		if _, err := parseSchedule("", hours, ""); err == nil {
+			t.Errorf("accepted %q", hours)
+		}
+	}
+}
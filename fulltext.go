@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// fullTextFetches is how many articles are fetched at once.
+const fullTextFetches = 8
+
+// fetchFullTexts replaces the content of the items with the article their
+// link leads to. Items whose article can't be fetched or found keep theirs.
+func fetchFullTexts(ctx context.Context, items []*gofeed.Item) {
+	var wg sync.WaitGroup
+	fetches := make(chan struct{}, fullTextFetches)
+	for _, item := range items {
+		if item.Link == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fetches <- struct{}{}
+			defer func() { <-fetches }()
+
+			page, err := fetchPage(ctx, item.Link)
+			if err != nil {
+				log.Printf("full text of %s: %v", item.Link, err)
+				return
+			}
+			if article := extractArticle(page, item.Link); article != "" {
+				item.Content = article
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+var (
+	// unreadableTags are left out of articles along with everything in them.
+	unreadableTags = map[atom.Atom]bool{
+		atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Nav: true, atom.Header: true,
+		atom.Footer: true, atom.Aside: true, atom.Form: true, atom.Button: true, atom.Iframe: true, atom.Svg: true,
+	}
+	// articleTags are the tags articles keep, other tags are dropped but their
+	// text is kept.
+	articleTags = map[atom.Atom]bool{
+		atom.P: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+		atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Blockquote: true, atom.Pre: true, atom.Code: true,
+		atom.A: true, atom.Img: true, atom.Em: true, atom.Strong: true, atom.B: true, atom.I: true, atom.Br: true,
+		atom.Figure: true, atom.Figcaption: true, atom.Table: true, atom.Tr: true, atom.Td: true, atom.Th: true,
+	}
+)
+
+// extractArticle finds the article in the page, the <article> if there is
+// one or else the element with the most text in paragraphs. It's cleaned up
+// to plain markup with absolute links, or "" if there's no article.
+func extractArticle(page []byte, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return ""
+	}
+
+	var article *html.Node
+	scores := map[*html.Node]int{}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && unreadableTags[node.DataAtom] {
+			return
+		}
+		if node.DataAtom == atom.Article && article == nil {
+			article = node
+		}
+		if node.DataAtom == atom.P && node.Parent != nil {
+			scores[node.Parent] += len(nodeText(node))
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	if article == nil {
+		best := 0
+		for node, score := range scores {
+			if score > best {
+				article, best = node, score
+			}
+		}
+	}
+	if article == nil {
+		return ""
+	}
+
+	var cleaned strings.Builder
+	for child := article.FirstChild; child != nil; child = child.NextSibling {
+		writeArticle(&cleaned, child, base)
+	}
+	return strings.TrimSpace(cleaned.String())
+}
+
+func writeArticle(w *strings.Builder, node *html.Node, base *url.URL) {
+	switch node.Type {
+	case html.TextNode:
+		w.WriteString(html.EscapeString(node.Data))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	if unreadableTags[node.DataAtom] {
+		return
+	}
+
+	keep := articleTags[node.DataAtom]
+	if keep {
+		w.WriteString("<" + node.Data)
+		for _, attr := range node.Attr {
+			switch {
+			case node.DataAtom == atom.A && attr.Key == "href", node.DataAtom == atom.Img && attr.Key == "src":
+				if link, err := base.Parse(attr.Val); err == nil {
+					w.WriteString(" " + attr.Key + `="` + html.EscapeString(link.String()) + `"`)
+				}
+			case node.DataAtom == atom.Img && attr.Key == "alt":
+				w.WriteString(` alt="` + html.EscapeString(attr.Val) + `"`)
+			}
+		}
+		w.WriteString(">")
+	}
+	if node.DataAtom == atom.Img || node.DataAtom == atom.Br {
+		return
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		writeArticle(w, child, base)
+	}
+	if keep {
+		w.WriteString("</" + node.Data + ">")
+	}
+}
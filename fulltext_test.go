@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// TestFullTextInternal checks that the articles items link to are only
+// fetched from the server's own addresses when allowed_hosts lists them.
+func TestFullTextInternal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><article><p>The whole article.</p></article></body></html>`))
+	}))
+	t.Cleanup(server.Close)
+
+	for _, test := range []struct {
+		name         string
+		allowedHosts []string
+		want         string
+	}{
+		{"unlisted", nil, "summary"},
+		{"listed", []string{"127.0.0.1"}, "<p>The whole article.</p>"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testConfig(t, func(conf *config) { conf.AllowedHosts = test.allowedHosts })
+			item := &gofeed.Item{Link: server.URL + "/" + test.name, Content: "summary"}
+			fetchFullTexts(context.Background(), []*gofeed.Item{item})
+			if item.Content != test.want {
+				t.Errorf("got content %q, want %q", item.Content, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+var githubRepoPath = regexp.MustCompile(`^/([\w.-]+)/([\w.-]+)(?:/releases(?:\.atom)?)?/?$`)
+
+// githubAPIURL is the releases in GitHub's API of the repository at the URL,
+// or of its releases page or feed. Tags have no more in the API than in
+// GitHub's tags.atom, which is read as it is, as repositories like golang/go
+// tag releases without making GitHub releases of them.
+func githubAPIURL(u *url.URL) (string, bool) {
+	if u.Host != "github.com" && u.Host != "www.github.com" {
+		return "", false
+	}
+	match := githubRepoPath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return "", false
+	}
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/releases?per_page=100", match[1], match[2]), true
+}
+
+type githubRelease struct {
+	Name       string    `json:"name"`
+	Tag        string    `json:"tag_name"`
+	URL        string    `json:"html_url"`
+	Body       string    `json:"body"`
+	Prerelease bool      `json:"prerelease"`
+	Published  time.Time `json:"published_at"`
+	Author     struct {
+		Login string `json:"login"`
+	} `json:"author"`
+}
+
+// unstableTag matches tags of builds that aren't releases, even if they
+// aren't marked as prereleases.
+var unstableTag = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:alpha|beta|rc|pre|dev|nightly|canary|snapshot|preview)\d*(?:[^a-z]|$)`)
+
+type githubParser struct{}
+
+// feed makes a feed of the releases, keeping which ones are prereleases for
+// stable_only.
+func (githubParser) feed(_ context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	var releases []githubRelease
+	if err := json.Unmarshal(page, &releases); err != nil {
+		return nil, fmt.Errorf("github: %w", err)
+	}
+
+	var repo string
+	if api, err := url.Parse(pageURL); err == nil {
+		repo = strings.TrimSuffix(strings.TrimPrefix(api.Path, "/repos/"), "/releases")
+	}
+	feed := &gofeed.Feed{Title: repo + " releases", Link: "https://github.com/" + repo + "/releases", FeedType: "atom"}
+	for _, release := range releases {
+		title := release.Name
+		if title == "" {
+			title = release.Tag
+		}
+		published := release.Published
+		item := &gofeed.Item{
+			Title:           title,
+			Link:            release.URL,
+			GUID:            release.URL,
+			Description:     release.Body,
+			Published:       published.Format(time.RFC3339),
+			PublishedParsed: &published,
+			Author:          &gofeed.Person{Name: release.Author.Login},
+			Authors:         []*gofeed.Person{{Name: release.Author.Login}},
+		}
+		prerelease := release.Prerelease || unstableTag.MatchString(release.Tag)
+		setSiteValue(item, "github", "prerelease", strconv.FormatBool(prerelease))
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// hackerNewsAPIURL is the search of HN's Algolia API that has the stories of
+// the front page or of the newest stories at the URL.
+func hackerNewsAPIURL(u *url.URL) (string, bool) {
+	if u.Host != "news.ycombinator.com" {
+		return "", false
+	}
+	switch u.Path {
+	case "", "/", "/news", "/front":
+		return "https://hn.algolia.com/api/v1/search?tags=front_page&hitsPerPage=100", true
+	case "/newest":
+		return "https://hn.algolia.com/api/v1/search_by_date?tags=story&hitsPerPage=100", true
+	}
+	return "", false
+}
+
+type hackerNewsSearch struct {
+	Hits []struct {
+		ID        string `json:"objectID"`
+		Title     string `json:"title"`
+		URL       string `json:"url"`
+		Author    string `json:"author"`
+		Created   int64  `json:"created_at_i"`
+		Points    int    `json:"points"`
+		Comments  int    `json:"num_comments"`
+		StoryText string `json:"story_text"`
+	} `json:"hits"`
+}
+
+type hackerNewsParser struct{}
+
+// feed makes a feed of the stories, keeping their points and comment counts
+// for the HN filters.
+func (hackerNewsParser) feed(_ context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	var search hackerNewsSearch
+	if err := json.Unmarshal(page, &search); err != nil {
+		return nil, fmt.Errorf("hacker news: %w", err)
+	}
+
+	feed := &gofeed.Feed{Title: "Hacker News", Link: "https://news.ycombinator.com/", FeedType: "rss"}
+	for _, story := range search.Hits {
+		comments := "https://news.ycombinator.com/item?id=" + story.ID
+		published := time.Unix(story.Created, 0).UTC()
+		item := &gofeed.Item{
+			Title:           story.Title,
+			Link:            story.URL,
+			GUID:            comments,
+			Published:       published.Format(time.RFC3339),
+			PublishedParsed: &published,
+			Author:          &gofeed.Person{Name: story.Author},
+			Authors:         []*gofeed.Person{{Name: story.Author}},
+			Description:     story.StoryText + fmt.Sprintf(`<p><a href="%s">%d points, %d comments</a></p>`, html.EscapeString(comments), story.Points, story.Comments),
+		}
+		if item.Link == "" {
+			item.Link = comments
+		}
+		setSiteValue(item, "hn", "points", strconv.Itoa(story.Points))
+		setSiteValue(item, "hn", "comments", strconv.Itoa(story.Comments))
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
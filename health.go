@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serving is whether the server is listening and not shutting down, readyz
+// fails otherwise so no new requests are sent its way.
+var serving atomic.Bool
+
+// readyTimeout is how long readyz waits for each of its checks.
+const readyTimeout = 5 * time.Second
+
+// canaryInterval is how long the result of fetching the canary is kept, so
+// frequent probes don't each fetch it.
+const canaryInterval = time.Minute
+
+var canary struct {
+	mu      sync.Mutex
+	feedURL string
+	checked time.Time
+	err     error
+}
+
+// pinger is a cacheStore that can tell whether it's reachable.
+type pinger interface {
+	ping(ctx context.Context) error
+}
+
+// healthzHandler answers as long as the process does.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler checks that the server is listening, its database and cache
+// are reachable and the ready_canary feed can be fetched, answering with the
+// result of each and 503 if any failed.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	checks := map[string]string{}
+	failed := false
+	check := func(name string, err error) {
+		checks[name] = "ok"
+		if err != nil {
+			checks[name] = err.Error()
+			failed = true
+		}
+	}
+	if serving.Load() {
+		check("listener", nil)
+	} else {
+		check("listener", errors.New("not listening"))
+	}
+	if database != nil {
+		check("database", database.PingContext(ctx))
+	}
+	if store, ok := responses.store.(pinger); ok {
+		check("cache", store.ping(ctx))
+	}
+	if cfg().ReadyCanary != "" {
+		check("canary", checkCanary(ctx, cfg().ReadyCanary))
+	}
+
+	status := http.StatusOK
+	result := "ok"
+	if failed {
+		status, result = http.StatusServiceUnavailable, "failing"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeAdminJSON(w, status, map[string]any{"status": result, "checks": checks})
+}
+
+// checkCanary fetches the feed, or says how fetching it went within the last
+// canaryInterval.
+func checkCanary(ctx context.Context, feedURL string) error {
+	canary.mu.Lock()
+	defer canary.mu.Unlock()
+	if canary.feedURL == feedURL && time.Since(canary.checked) < canaryInterval {
+		return canary.err
+	}
+	_, _, err := fetchPageOnce(ctx, feedURL)
+	canary.feedURL, canary.checked, canary.err = feedURL, time.Now(), err
+	return err
+}
+
+func (c *redisCache) ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// ping checks that the cache's directory is still there to write to.
+func (c *diskCache) ping(ctx context.Context) error {
+	file, err := os.CreateTemp(c.dir, ".ready-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
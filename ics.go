@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// writeICS writes the items as iCalendar events. An event is on the date
+// date_re finds in the title, as an all-day event if there's no time, or else
+// at the time the item was published.
+func writeICS(w io.Writer, feed *gofeed.Feed, opts *renderOptions) error {
+	var cal strings.Builder
+	line := func(name, value string) { cal.WriteString(foldICSLine(name + ":" + value)) }
+
+	stamp := feedModified(feed)
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	now := stamp.UTC().Format("20060102T150405Z")
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//rerss//rerss//EN")
+	line("X-WR-CALNAME", escapeICS(feed.Title))
+	for _, item := range feed.Items {
+		start, allDay, found := eventStart(item, opts.eventDate)
+		if !found {
+			continue
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", escapeICS(itemID(item)))
+		line("DTSTAMP", now)
+		if allDay {
+			line("DTSTART;VALUE=DATE", start.Format("20060102"))
+		} else {
+			line("DTSTART", start.UTC().Format("20060102T150405Z"))
+		}
+		line("SUMMARY", escapeICS(item.Title))
+		if item.Link != "" {
+			line("URL", item.Link)
+		}
+		if text := itemText(item); text != "" {
+			line("DESCRIPTION", escapeICS(text))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+
+	_, err := io.WriteString(w, cal.String())
+	return err
+}
+
+// eventStart finds when the item's event is, found is false for items
+// without any date.
+func eventStart(item *gofeed.Item, dateRegex *regexp.Regexp) (start time.Time, allDay, found bool) {
+	if dateRegex != nil {
+		if match := dateRegex.FindStringSubmatch(item.Title); match != nil {
+			text := match[0]
+			if len(match) > 1 {
+				text = match[1]
+			}
+			if start, hasTime, ok := parseTextDate(text); ok {
+				return start, !hasTime, true
+			}
+		}
+	}
+	if date := itemDate(item); date != nil {
+		return *date, false, true
+	}
+	return time.Time{}, false, false
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", "")
+
+func escapeICS(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// foldICSLine breaks the content line into lines of at most 75 bytes, as
+// iCalendar requires, without splitting characters.
+func foldICSLine(line string) string {
+	var folded strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		folded.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74 // the continuation lines start with a space
+	}
+	folded.WriteString(line + "\r\n")
+	return folded.String()
+}
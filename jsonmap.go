@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// jsonMapper makes a feed of a JSON document, the paths lead from the
+// document to the array of items and from each item to its parts. A path is
+// keys and array indices separated by dots, like data.children.
+type jsonMapper struct {
+	items, title, link, date []string
+}
+
+// parseJSONMapper reads the paths of the JSON mode, it's nil when the
+// sources are feeds.
+func parseJSONMapper(query url.Values) (*jsonMapper, error) {
+	if !query.Has("map_title") && !query.Has("map_link") {
+		for _, name := range []string{"map_items", "map_date"} {
+			if query.Has(name) {
+				return nil, fmt.Errorf("'%s' needs 'map_title' or 'map_link'", name)
+			}
+		}
+		return nil, nil
+	}
+	return &jsonMapper{
+		items: jsonPath(query.Get("map_items")),
+		title: jsonPath(query.Get("map_title")),
+		link:  jsonPath(query.Get("map_link")),
+		date:  jsonPath(query.Get("map_date")),
+	}, nil
+}
+
+func jsonPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func (m *jsonMapper) feed(_ context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	var doc any
+	if err := json.Unmarshal(page, &doc); err != nil {
+		return nil, err
+	}
+	items, ok := lookupJSON(doc, m.items).([]any)
+	if !ok {
+		return nil, errors.New("'map_items' doesn't lead to an array")
+	}
+
+	feed := &gofeed.Feed{Title: pageURL, Link: pageURL, FeedType: "rss"}
+	for _, value := range items {
+		item := &gofeed.Item{}
+		if m.title != nil {
+			item.Title = jsonString(lookupJSON(value, m.title))
+		}
+		if m.link != nil {
+			item.Link = jsonString(lookupJSON(value, m.link))
+		}
+		if m.date != nil {
+			item.Published = jsonString(lookupJSON(value, m.date))
+			item.PublishedParsed = jsonDate(lookupJSON(value, m.date))
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
+
+// lookupJSON follows the path in the decoded value, it's nil if the path
+// leads nowhere.
+func lookupJSON(value any, path []string) any {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func jsonString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// jsonDate reads a date as text or as seconds since the Unix epoch.
+func jsonDate(value any) *time.Time {
+	switch v := value.(type) {
+	case string:
+		if date, _, ok := parseTextDate(v); ok {
+			return &date
+		}
+	case float64:
+		date := time.Unix(int64(v), 0).UTC()
+		return &date
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestJSONMapperInternal checks that JSON sources are only fetched from the
+// server's own addresses when allowed_hosts lists them.
+func TestJSONMapperInternal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"posts": [{"title": "Go 1.24", "url": "https://example.com/1"}]}`))
+	}))
+	t.Cleanup(server.Close)
+	mapper, err := parseJSONMapper(url.Values{"map_items": {"posts"}, "map_title": {"title"}, "map_link": {"url"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testConfig(t, func(conf *config) { conf.AllowedHosts = nil })
+	if _, err := fetchFeed(context.Background(), server.URL+"/posts.json", mapper); !errors.Is(err, errInternalAddress) {
+		t.Errorf("got %v, want %v", err, errInternalAddress)
+	}
+	testConfig(t, func(conf *config) {})
+	feed, err := fetchFeed(context.Background(), server.URL+"/listed.json", mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 1 || feed.Items[0].Title != "Go 1.24" {
+		t.Errorf("got items %v", feed.Items)
+	}
+}
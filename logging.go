@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logLevel is the level of the config, reloading it changes it at once.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging sends the logs, the log package's too, to stderr as text or
+// JSON lines.
+func setupLogging(conf *config) {
+	logLevel.Set(conf.logLevel)
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if conf.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// requestLogKey holds the requestLog of the request being served in its
+// context, for the handlers to add to.
+type requestLogKey struct{}
+
+type requestLog struct {
+	// upstreams are the hosts of the feeds the request is for
+	upstreams []string
+}
+
+// noteUpstreams adds the hosts of the feed URLs to the log of the request ctx
+// is for, if it's logged.
+func noteUpstreams(ctx context.Context, feedURLs []string) {
+	entry, ok := ctx.Value(requestLogKey{}).(*requestLog)
+	if !ok {
+		return
+	}
+	for _, feedURL := range feedURLs {
+		if u, err := url.Parse(feedURL); err == nil && !slices.Contains(entry.upstreams, u.Host) {
+			entry.upstreams = append(entry.upstreams, u.Host)
+		}
+	}
+}
+
+// logRequests logs every request to the handler once it's served, failed ones
+// with the error they got and at a higher level. The query is left out, as
+// keys and credentials can be in it.
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		entry := &requestLog{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, entry)))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Duration("took", time.Since(started)),
+			slog.Int64("bytes", recorder.bytes),
+			slog.String("client", clientIP(r)),
+		}
+		if len(entry.upstreams) > 0 {
+			attrs = append(attrs, slog.String("upstream", strings.Join(entry.upstreams, ",")))
+		}
+		level := slog.LevelInfo
+		if recorder.status >= 400 {
+			level = slog.LevelWarn
+			attrs = append(attrs, slog.String("error", strings.TrimSpace(recorder.errorText.String())))
+		}
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// withoutQuery is the URL without its query, where the API keys of the
+// adapters go, for logging it.
+func withoutQuery(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "?"
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// clientIP is the address the request came from, the first one of
+// X-Forwarded-For behind a proxy.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder remembers the status code written through it, how much was
+// written and the start of the error of failed requests.
+type statusRecorder struct {
+	http.ResponseWriter
+	status    int
+	bytes     int64
+	errorText strings.Builder
+}
+
+// maxErrorText is how much of an error statusRecorder keeps.
+const maxErrorText = 256
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status >= 400 && r.errorText.Len() < maxErrorText {
+		r.errorText.Write(data[:min(len(data), maxErrorText-r.errorText.Len())])
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
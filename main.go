@@ -1,142 +1,501 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"regexp"
-	"runtime"
 	"slices"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/feeds"
 	"github.com/mmcdole/gofeed"
-	"github.com/shirou/gopsutil/v4/cpu"
-	"github.com/shirou/gopsutil/v4/mem"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 //go:embed index.html
 var indexHTML []byte
 
 func main() {
-	http.HandleFunc("/", indexHandler)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+		command = "version"
+	}
+	switch command {
+	case "serve":
+		serve(args)
+	case "filter":
+		filter(args)
+	case "sign":
+		sign(args)
+	case "check-config":
+		checkConfig(args)
+	case "version":
+		fmt.Println("rerss", build())
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	configPath := flags.String("config", os.Getenv("CONFIG"), "")
+	addr := flags.String("addr", "", "")
+	flags.Parse(args)
+	if err := setup(*configPath); err != nil {
+		log.Fatal(err)
+	}
+	if *addr == "" {
+		if cfg().Port == "" {
+			fmt.Fprintf(os.Stderr, "nowhere to listen, set -addr, PORT or port in the config\n\n%s", usage)
+			os.Exit(2)
+		}
+		*addr = fmt.Sprintf("[%s]:%s", cfg().IP, cfg().Port)
+	}
+	shutdownTracing, err := setupTracing(cfg().OTLPEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.HandleFunc("/", keyRequired(indexHandler))
+	http.HandleFunc("/u/{user}/{$}", keyRequired(indexHandler))
 	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/version", versionHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/feed.xsl", stylesheetHandler)
+	for _, prefix := range []string{"", "/u/{user}"} {
+		http.HandleFunc(prefix+"/f/{slug}", keyRequiredToChange(savedFeedHandler))
+		http.HandleFunc("GET "+prefix+"/preview", keyRequired(previewHandler))
+		http.HandleFunc("GET "+prefix+"/admin/sign", adminOnly(signHandler))
+		http.HandleFunc("GET "+prefix+"/admin/export", adminOnly(exportHandler))
+		http.HandleFunc("POST "+prefix+"/admin/import", adminOnly(importHandler))
+		http.HandleFunc("GET "+prefix+"/admin/feeds", adminOnly(listFeedsHandler))
+		http.HandleFunc("GET "+prefix+"/admin/presets", adminOnly(presetsHandler))
+		http.HandleFunc("GET "+prefix+"/admin/feeds.opml", adminOnly(exportFeedsHandler))
+		http.HandleFunc("POST "+prefix+"/admin/feeds.opml", adminOnly(importFeedsHandler))
+		http.HandleFunc("POST "+prefix+"/admin/feeds", adminOnly(createFeedHandler))
+		http.HandleFunc("GET "+prefix+"/admin/feeds/{slug}", adminOnly(getFeedHandler))
+		http.HandleFunc("PUT "+prefix+"/admin/feeds/{slug}", adminOnly(putFeedHandler))
+		http.HandleFunc("DELETE "+prefix+"/admin/feeds/{slug}", adminOnly(deleteFeedHandler))
+	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+	go reloadOnHangup(ctx, *configPath)
+	statsSaved := make(chan struct{})
+	go func() {
+		defer close(statsSaved)
+		if database != nil {
+			saveStats(ctx, database)
+		}
+	}()
 
-	ip6, port := os.Getenv("IP"), os.Getenv("PORT")
-	addr := fmt.Sprintf("[%s]:%s", ip6, port)
-
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
 	server := &http.Server{
-		BaseContext: func(net.Listener) context.Context { return ctx },
-		Addr:        addr,
+		Handler:      logRequests(instrumented(http.DefaultServeMux)),
+		ReadTimeout:  cfg().ReadTimeout,
+		WriteTimeout: cfg().WriteTimeout,
 	}
-	server.ListenAndServe()
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	serving.Store(true)
+	log.Printf("listening on %s", listener.Addr())
+
+	select {
+	case err := <-served:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	// with the signals stopped a second one ends the server without waiting
+	cancel()
+	serving.Store(false)
+	log.Print("shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("waiting for the requests: %v", err)
+		server.Close()
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("sending the traces: %v", err)
+	}
+	<-statsSaved
 }
 
+// shutdownTimeout is how long the requests being served are waited for when
+// shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// setup loads the config and what it says to keep state in.
+func setup(configPath string) error {
+	conf, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	currentConfig.Store(conf)
+	setupLogging(conf)
+
+	switch {
+	case cfg().DatabaseURL != "":
+		database, err = openPostgres(cfg().DatabaseURL)
+	case cfg().DataDir != "":
+		database, err = openDatabase(cfg().DataDir)
+	}
+	if err != nil {
+		return err
+	}
+	if database != nil {
+		if err := stats.load(database); err != nil {
+			return err
+		}
+	}
+	if seenItems, err = loadSeenStore(database, cfg().DataDir); err != nil {
+		return err
+	}
+	switch {
+	case cfg().CacheDir != "":
+		responses.store, err = newDiskCache(cfg().CacheDir, cfg().CacheMaxSize)
+	case cfg().Redis != "":
+		responses.store, err = newRedisCache(cfg().Redis)
+	case database != nil:
+		responses.store = &sqlCache{db: database, maxSize: cfg().CacheMaxSize}
+	}
+	return err
+}
+
+// indexHandler serves the feed the query makes, or the index page without
+// one. Under /u/<name>/ the user's presets can be used too.
 func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg().knownUser(r.PathValue("user")) {
+		http.NotFound(w, r)
+		return
+	}
 	query := r.URL.Query()
 	if len(query) == 0 {
 		w.Write(indexHTML)
 		return
 	}
+	serveQuery(w, r, query, selfURL(r))
+}
 
-	var keepItem func(title string) bool
-	if query.Has("re") {
-		pattern := query.Get("re")
-		regex, err := regexp.Compile(pattern)
+// savedFeedHandler serves the saved feed of the slug in the path, of the user
+// in it if there is one. Parameters in the query come first, like with
+// presets. The saved parameters and credentials are part of the cache key, so
+// editing them takes effect at once. The credentials are only used when the
+// query just changes how the feed is shown.
+func savedFeedHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !cfg().knownUser(user) {
+		http.NotFound(w, r)
+		return
+	}
+	slug := r.PathValue("slug")
+	saved, found, err := savedFeed(user, slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	cacheKey := selfURL(r) + "#" + saved.values().Encode()
+	if _, inConfig := cfg().feeds(user)[slug]; !inConfig && onlyDisplayParams(r.URL.Query()) {
+		encrypted, err := storedCredentials(user, slug)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		keepItem = regex.MatchString
-	} else if skips, specified := query["skip"]; specified {
-		keepItem = func(contents string) bool {
-			for _, word := range strings.Fields(contents) {
-				if slices.Contains(skips, word) {
-					return false
-				}
+		if encrypted != nil {
+			credentials, err := decryptCredentials(encrypted)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
-			return true
+			r = r.WithContext(withCredentials(r.Context(), saved, credentials))
+			sum := sha256.Sum256(encrypted)
+			cacheKey += "#" + hex.EncodeToString(sum[:8])
 		}
-	} else {
-		http.Error(w, "missing 'skip' or 're'", http.StatusBadRequest)
+	}
+	query := r.URL.Query()
+	for key, values := range saved {
+		query[key] = append(query[key], values...)
+	}
+	serveQuery(w, r, query, cacheKey)
+}
+
+// serveQuery serves the feed the query makes, cacheKey tells it apart from
+// the others in the cache. What a user was served is kept apart from what
+// others were.
+func serveQuery(w http.ResponseWriter, r *http.Request, query url.Values, cacheKey string) {
+	user := r.PathValue("user")
+	query.Del("key")
+	query.Del("sig")
+	seenKey := query.Encode()
+	if user != "" {
+		seenKey = user + ":" + seenKey
+	}
+	if err := applyPresets(query, cfg().presets(user)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	noteUpstreams(r.Context(), query["url"])
+	if query.Get("debug") == "1" {
+		servePreview(w, r, query)
 		return
 	}
 
-	if !query.Has("url") {
-		http.Error(w, "missing 'url'", http.StatusBadRequest)
+	keepItem, err := parseFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	rssURL := query.Get("url")
 
-	err := writeFilteredRSS(w, keepItem, rssURL)
+	arrange, err := parseArrange(query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-}
 
-func writeFilteredRSS(w io.Writer, keepItem func(title string) bool, rssURL string) error {
-	originalFeed, err := gofeed.NewParser().ParseURL(rssURL)
+	transform, err := parseTransform(query)
 	if err != nil {
-		return err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	render, err := parseRender(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	render.self = selfURL(r)
+
+	parser, err := parseSource(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	filteredFeed := &feeds.Feed{
-		Title:       originalFeed.Title,
-		Link:        &feeds.Link{Href: originalFeed.Link},
-		Description: originalFeed.Description,
-		Created:     time.Now(),
+	headers, err := parseRequestHeaders(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if originalFeed.Author != nil {
-		filteredFeed.Author = &feeds.Author{Name: originalFeed.Author.Name, Email: originalFeed.Author.Email}
+	ctx := context.WithValue(r.Context(), requestHeadersKey{}, headers)
+
+	mode := query.Get("mode")
+	if mode != "" && mode != "merge" && mode != "rewrite" {
+		http.Error(w, "'mode' must be 'merge' or 'rewrite'", http.StatusBadRequest)
+		return
 	}
-	for _, item := range originalFeed.Items {
-		keep := keepItem(item.Title)
-		if keep {
-			filteredFeed.Items = append(filteredFeed.Items, &feeds.Item{
-				Title:       item.Title,
-				Link:        &feeds.Link{Href: item.Link},
-				Description: item.Description,
-				Author:      &feeds.Author{Name: item.Author.Name, Email: item.Author.Email},
-				Created:     *item.PublishedParsed,
-			})
+	if query.Has("opml") && mode == "rewrite" {
+		list, err := fetchOPML(ctx, query.Get("opml"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		self, _ := url.Parse(render.self)
+		list.rewrite(self)
+		if err := writeOPML(w, list); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
+		return
+	}
+	if !query.Has("url") && !query.Has("opml") {
+		http.Error(w, "missing 'url' or 'opml'", http.StatusBadRequest)
+		return
 	}
 
-	return filteredFeed.WriteRss(w)
+	newOnly := query.Get("newonly") == "1"
+	refresh := func(ctx context.Context) (*cachedResponse, error) {
+		urls := query["url"]
+		if query.Has("opml") {
+			list, err := fetchOPML(ctx, query.Get("opml"))
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, list.feedURLs()...)
+		}
+		if len(urls) == 0 {
+			return nil, errors.New("no feeds in the OPML")
+		}
+
+		var originalFeed *gofeed.Feed
+		var sources sourceFeeds
+		var err error
+		if streamable(query, parser) {
+			var response *cachedResponse
+			if response, originalFeed, err = fetchStreamed(ctx, urls[0], render.self, keepItem); err != nil {
+				return nil, err
+			}
+			if response != nil {
+				responses.set(cacheKey, response, cacheTTL(urls))
+				return response, nil
+			}
+			sources = sourcesOf(originalFeed)
+		} else if originalFeed, sources, err = fetchFeeds(ctx, urls, query["fallback"], parser); err != nil {
+			return nil, err
+		}
+		fetched := len(originalFeed.Items)
+		_, span := startSpan(ctx, "filter")
+		originalFeed.Items = slices.DeleteFunc(originalFeed.Items, func(item *gofeed.Item) bool { return !keepItem(item) })
+		countFiltered(len(originalFeed.Items), fetched-len(originalFeed.Items))
+		if newOnly {
+			if originalFeed.Items, err = seenItems.unseen(seenKey, originalFeed.Items); err != nil {
+				endSpan(span, err)
+				return nil, err
+			}
+		}
+		span.SetAttributes(attribute.Int("rerss.items", fetched), attribute.Int("rerss.kept", len(originalFeed.Items)))
+		span.End()
+
+		_, span = startSpan(ctx, "arrange")
+		originalFeed.Items = arrange(originalFeed.Items)
+		span.End()
+		if query.Get("fulltext") == "1" {
+			fullTextCtx, span := startSpan(ctx, "full texts")
+			fetchFullTexts(fullTextCtx, originalFeed.Items)
+			span.End()
+		}
+		for _, item := range originalFeed.Items {
+			transform(item, sources[item])
+		}
+		if newOnly {
+			if err := seenItems.markSeen(seenKey, originalFeed.Items); err != nil {
+				log.Printf("saving seen items: %v", err)
+			}
+		}
+
+		_, span = startSpan(ctx, "render")
+		contentType, body, err := renderFeed(originalFeed, render)
+		span.SetAttributes(attribute.String("rerss.format", render.format), attribute.Int("rerss.bytes", len(body)))
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+		response := &cachedResponse{
+			contentType: contentType,
+			body:        body,
+			modified:    feedModified(originalFeed),
+			nextRefresh: nextRefresh(time.Now(), originalFeed),
+		}
+		if !newOnly {
+			responses.set(cacheKey, response, cacheTTL(urls))
+		}
+		return response, nil
+	}
+
+	if next, refreshing := r.Context().Value(refreshingKey{}).(*time.Time); refreshing {
+		response, err := refresh(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		*next = response.nextRefresh
+		serveFeed(w, r, response)
+		return
+	}
+	cached, fresh, found := responses.get(cacheKey)
+	if !newOnly {
+		stats.cacheLookup(found, fresh)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("rerss.cache.found", found), attribute.Bool("rerss.cache.fresh", fresh))
+	}
+	if found && !newOnly {
+		// A stale feed is served as it is while it's refreshed, so the readers
+		// don't see the upstream failing now and then.
+		if !fresh {
+			go func() {
+				_, err, _ := feedRefreshes.Do(cacheKey, func() (any, error) {
+					return refresh(context.WithoutCancel(ctx))
+				})
+				if err != nil {
+					log.Printf("refreshing %s: %v", cacheKey, err)
+				}
+			}()
+		}
+		serveFeed(w, r, cached)
+		return
+	}
+	response, err := refresh(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveFeed(w, r, response)
 }
 
-var statusPattern = strings.TrimSpace(`
-CPU used:	%.2f%%
-RAM used:	%d / %d / %d MB (%.0f%%)
-Goroutines:	%d
-`)
+// feedRefreshes keeps stale feeds from being refreshed more than once at a
+// time.
+var feedRefreshes singleflight.Group
 
-func statusHandler(w http.ResponseWriter, r *http.Request) {
-	cpuUsages, _ := cpu.Percent(0, false)
-	cpuUsage := 0.0
-	if len(cpuUsages) > 0 {
-		cpuUsage = cpuUsages[0]
+// serveFeed writes the response with an ETag of its body and when it was
+// modified, so readers polling it can be told it hasn't changed. It's
+// compressed when the reader accepts it.
+func serveFeed(w http.ResponseWriter, r *http.Request, response *cachedResponse) {
+	sum := sha256.Sum256(response.body)
+	body, etag := response.body, hex.EncodeToString(sum[:16])
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding := acceptedEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+		if compressed, err := compress(body, encoding); err != nil {
+			log.Printf("compressing with %s: %v", encoding, err)
+		} else {
+			body, etag = compressed, etag+"-"+encoding
+			w.Header().Set("Content-Encoding", encoding)
+		}
 	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Content-Type", response.contentType)
+	http.ServeContent(w, r, "", response.modified, bytes.NewReader(body))
+}
 
-	goMem := &runtime.MemStats{}
-	runtime.ReadMemStats(goMem)
-	sysMem, _ := mem.VirtualMemory()
+func stylesheetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xsl; charset=utf-8")
+	w.Write(feedXSL)
+}
 
-	numGos := runtime.NumGoroutine()
+// selfURL is the address the request was made to, as the client sees it when
+// behind a proxy.
+func selfURL(r *http.Request) string {
+	return baseURL(r) + r.URL.RequestURI()
+}
 
-	fmt.Fprintf(w, statusPattern,
-		cpuUsage,
-		// memStat.Used/1_024/1_024, memStat.Total/1_024/1_024, memStat.UsedPercent)
-		// stats on this host are off by a 1024...
-		goMem.Sys/1_024/1_024, sysMem.Used/1_024/1_024/1_024, sysMem.Total/1_024/1_024/1_024, sysMem.UsedPercent,
-		numGos)
+// baseURL is where the server is as the reader sees it.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+var mastodonProfilePath = regexp.MustCompile(`^/@(\w+)/?$`)
+
+// mastodonAPIURL is where the Mastodon API looks up the account of the
+// profile at the URL. Other sites have /@name pages too, so the known ones are
+// left to their feeds, and fetchFeed falls back to the page when the lookup
+// fails on the others.
+func mastodonAPIURL(u *url.URL) (string, bool) {
+	match := mastodonProfilePath.FindStringSubmatch(u.Path)
+	if match == nil || u.Host == "medium.com" || youtubeHost(u.Host) {
+		return "", false
+	}
+	lookup := url.URL{Scheme: "https", Host: u.Host, Path: "/api/v1/accounts/lookup", RawQuery: url.Values{"acct": {match[1]}}.Encode()}
+	return lookup.String(), true
+}
+
+type mastodonStatus struct {
+	URL     string          `json:"url"`
+	Created time.Time       `json:"created_at"`
+	Content string          `json:"content"`
+	Spoiler string          `json:"spoiler_text"`
+	ReplyTo *string         `json:"in_reply_to_id"`
+	Reblog  *mastodonStatus `json:"reblog"`
+	Account struct {
+		Acct        string `json:"acct"`
+		DisplayName string `json:"display_name"`
+		URL         string `json:"url"`
+	} `json:"account"`
+	Media []struct {
+		URL string `json:"url"`
+	} `json:"media_attachments"`
+}
+
+type mastodonParser struct{}
+
+// feed gets the statuses of the account that was looked up, boosts show the
+// boosted post. Which ones are boosts and replies is kept for the filters.
+func (mastodonParser) feed(ctx context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	var account struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+		URL         string `json:"url"`
+	}
+	if err := json.Unmarshal(page, &account); err != nil {
+		return nil, fmt.Errorf("mastodon: %w", err)
+	}
+	lookup, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	statusesURL := url.URL{Scheme: lookup.Scheme, Host: lookup.Host, Path: "/api/v1/accounts/" + account.ID + "/statuses", RawQuery: "limit=40"}
+	body, err := fetchPage(ctx, statusesURL.String())
+	if err != nil {
+		return nil, err
+	}
+	var statuses []mastodonStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		return nil, fmt.Errorf("mastodon: %w", err)
+	}
+
+	feed := &gofeed.Feed{Title: account.DisplayName, Link: account.URL, FeedType: "rss"}
+	for _, status := range statuses {
+		post := status
+		if status.Reblog != nil {
+			post = *status.Reblog
+		}
+		title := post.Spoiler
+		if title == "" {
+			title = truncate(plainText(post.Content), postTitleLength)
+		}
+		published := status.Created
+		author := post.Account.DisplayName
+		if author == "" {
+			author = post.Account.Acct
+		}
+		item := &gofeed.Item{
+			Title:           title,
+			Link:            post.URL,
+			GUID:            post.URL,
+			Description:     post.Content,
+			Published:       published.Format(time.RFC3339),
+			PublishedParsed: &published,
+			Author:          &gofeed.Person{Name: author},
+			Authors:         []*gofeed.Person{{Name: author}},
+		}
+		for _, media := range post.Media {
+			item.Enclosures = append(item.Enclosures, &gofeed.Enclosure{URL: media.URL, Type: guessMIMEType(media.URL)})
+		}
+		setSiteValue(item, "social", "boost", strconv.FormatBool(status.Reblog != nil))
+		setSiteValue(item, "social", "reply", strconv.FormatBool(post.ReplyTo != nil))
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
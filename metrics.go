@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"maps"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// metrics counts how the cache and the fetches from upstreams are doing, for
+// the status page.
+type metrics struct {
+	mu sync.Mutex
+	counts
+	// saved are the counts as they were last loaded from or saved to the
+	// database, saving adds what was counted since to the database's so
+	// instances sharing one don't count over each other
+	saved counts
+}
+
+type counts struct {
+	hits, stale, misses int
+	hosts               map[string]*hostMetrics
+}
+
+func (c counts) clone() counts {
+	c.hosts = maps.Clone(c.hosts)
+	for host, hostStats := range c.hosts {
+		copied := *hostStats
+		c.hosts[host] = &copied
+	}
+	return c
+}
+
+type hostMetrics struct {
+	fetches, errors int
+	took            time.Duration
+}
+
+var stats = &metrics{counts: counts{hosts: map[string]*hostMetrics{}}}
+
+// maxStatsHosts is how many upstream hosts are counted apart, the rest are
+// counted together as "other".
+const maxStatsHosts = 100
+
+// statsInterval is how often the counts are saved to the database.
+const statsInterval = time.Minute
+
+// cacheLookup counts a request for a feed, found in the cache or not.
+func (m *metrics) cacheLookup(found, fresh bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case found && fresh:
+		m.hits++
+		cacheLookups.add(1, "result", "fresh")
+	case found:
+		m.stale++
+		cacheLookups.add(1, "result", "stale")
+	default:
+		m.misses++
+		cacheLookups.add(1, "result", "miss")
+	}
+}
+
+// fetched counts a fetch from the upstream at the URL.
+func (m *metrics) fetched(pageURL string, took time.Duration, err error) {
+	host := pageURL
+	if u, err := url.Parse(pageURL); err == nil {
+		host = u.Host
+	}
+	label := hostLabel(host)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hostStats, found := m.hosts[host]
+	if !found && len(m.hosts) >= maxStatsHosts {
+		host = "other"
+		hostStats, found = m.hosts[host]
+	}
+	if !found {
+		hostStats = &hostMetrics{}
+		m.hosts[host] = hostStats
+	}
+	hostStats.fetches++
+	hostStats.took += took
+	upstreamFetches.add(1, "host", label)
+	fetchSeconds.observe(took)
+	if err != nil {
+		hostStats.errors++
+		upstreamErrors.add(1, "host", label)
+	}
+}
+
+// status is the counts of the cache and of each upstream host for the status
+// page.
+func (m *metrics) status() (cacheStatus, map[string]upstreamStatus) {
+	feeds, size := responses.size()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cache := cacheStatus{Fresh: m.hits, Stale: m.stale, Missed: m.misses, Feeds: feeds, Bytes: size}
+	upstreams := map[string]upstreamStatus{}
+	for host, hostStats := range m.hosts {
+		upstreams[host] = upstreamStatus{
+			Fetches:   hostStats.fetches,
+			Errors:    hostStats.errors,
+			AverageMS: (hostStats.took / time.Duration(max(hostStats.fetches, 1))).Milliseconds(),
+		}
+	}
+	return cache, upstreams
+}
+
+// load starts the counts from the ones saved in the database.
+func (m *metrics) load(db *stateDB) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := db.QueryRow(`SELECT hits, stale, misses FROM cache_stats`).Scan(&m.hits, &m.stale, &m.misses)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	rows, err := db.Query(`SELECT host, fetches, errors, took FROM host_stats`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var host string
+		var took int64
+		hostStats := &hostMetrics{}
+		if err := rows.Scan(&host, &hostStats.fetches, &hostStats.errors, &took); err != nil {
+			return err
+		}
+		hostStats.took = time.Duration(took)
+		m.hosts[host] = hostStats
+	}
+	m.saved = m.counts.clone()
+	return rows.Err()
+}
+
+// save adds what was counted since the last save to the database.
+func (m *metrics) save(db *stateDB) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO cache_stats (id, hits, stale, misses) VALUES (1, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET hits = cache_stats.hits + excluded.hits,
+			stale = cache_stats.stale + excluded.stale, misses = cache_stats.misses + excluded.misses`,
+		m.hits-m.saved.hits, m.stale-m.saved.stale, m.misses-m.saved.misses); err != nil {
+		return err
+	}
+	for host, hostStats := range m.hosts {
+		saved := m.saved.hosts[host]
+		if saved == nil {
+			saved = &hostMetrics{}
+		}
+		if _, err := tx.Exec(`INSERT INTO host_stats (host, fetches, errors, took) VALUES (?, ?, ?, ?)
+			ON CONFLICT (host) DO UPDATE SET fetches = host_stats.fetches + excluded.fetches,
+				errors = host_stats.errors + excluded.errors, took = host_stats.took + excluded.took`,
+			host, hostStats.fetches-saved.fetches, hostStats.errors-saved.errors, int64(hostStats.took-saved.took)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.saved = m.counts.clone()
+	return nil
+}
+
+// saveStats saves the counts every statsInterval, and once more when ctx is
+// done.
+func saveStats(ctx context.Context, db *stateDB) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+		if err := stats.save(db); err != nil {
+			log.Printf("saving stats: %v", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const opmlMaxSize = 1 << 20
+
+// opmlClient fetches subscription lists, like blocklistClient a slow one
+// can't hold up the request for long.
+var opmlClient = &http.Client{Timeout: 10 * time.Second, Transport: feedClient.Transport, CheckRedirect: checkRedirect}
+
+type (
+	opml struct {
+		XMLName xml.Name       `xml:"opml"`
+		Version string         `xml:"version,attr"`
+		Title   string         `xml:"head>title"`
+		Body    []*opmlOutline `xml:"body>outline"`
+	}
+	opmlOutline struct {
+		Text     string         `xml:"text,attr"`
+		Title    string         `xml:"title,attr,omitempty"`
+		Type     string         `xml:"type,attr,omitempty"`
+		XMLURL   string         `xml:"xmlUrl,attr,omitempty"`
+		HTMLURL  string         `xml:"htmlUrl,attr,omitempty"`
+		Outlines []*opmlOutline `xml:"outline"`
+	}
+)
+
+func fetchOPML(ctx context.Context, listURL string) (*opml, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opml %s: %w", listURL, err)
+	}
+	if !allowedHost(req.URL.Hostname()) {
+		return nil, fmt.Errorf("opml %s: %s isn't an allowed host", listURL, req.URL.Hostname())
+	}
+	resp, err := opmlClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("opml %s: %w", listURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("opml %s: %s", listURL, resp.Status)
+	}
+	list := &opml{}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, opmlMaxSize)).Decode(list); err != nil {
+		return nil, fmt.Errorf("opml %s: %w", listURL, err)
+	}
+	return list, nil
+}
+
+// feedOutlines lists the outlines with a feed, from every folder.
+func (list *opml) feedOutlines() []*opmlOutline {
+	var feeds []*opmlOutline
+	var walk func(outlines []*opmlOutline)
+	walk = func(outlines []*opmlOutline) {
+		for _, outline := range outlines {
+			if outline.XMLURL != "" {
+				feeds = append(feeds, outline)
+			}
+			walk(outline.Outlines)
+		}
+	}
+	walk(list.Body)
+	return feeds
+}
+
+func (list *opml) feedURLs() []string {
+	var urls []string
+	for _, outline := range list.feedOutlines() {
+		urls = append(urls, outline.XMLURL)
+	}
+	return urls
+}
+
+// rewrite points every feed of the list at its filtered version, made with
+// the parameters of the rerss URL base except the list itself.
+func (list *opml) rewrite(base *url.URL) {
+	for _, outline := range list.feedOutlines() {
+		query := base.Query()
+		query.Del("opml")
+		query.Del("mode")
+		query.Set("url", outline.XMLURL)
+		rewritten := *base
+		rewritten.RawQuery = query.Encode()
+		outline.XMLURL = rewritten.String()
+	}
+}
+
+func writeOPML(w http.ResponseWriter, list *opml) error {
+	list.Version = "2.0"
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(list)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// preview is the summary of a feed /preview serves.
+type preview struct {
+	Title       string `json:"title"`
+	Link        string `json:"link"`
+	Description string `json:"description"`
+	// Format is the format the feed came in, like rss
+	Format  string `json:"format"`
+	Total   int    `json:"total"`
+	Kept    int    `json:"kept"`
+	Dropped int    `json:"dropped"`
+	// Warnings are what went wrong without failing the feed, like a merged
+	// feed failing to load or items without dates
+	Warnings []string      `json:"warnings"`
+	Items    []previewItem `json:"items"`
+}
+
+// previewItem is an item of the feed as /preview shows it, with whether the
+// filters keep it and the ones that drop it if not.
+type previewItem struct {
+	jsonItem
+	Kept      bool     `json:"kept"`
+	DroppedBy []string `json:"dropped_by,omitempty"`
+}
+
+// previewHandler serves the preview of the feed the query makes, for the
+// index page to show what a filter does while it's typed.
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !cfg().knownUser(user) {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if err := applyPresets(query, cfg().presets(user)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	servePreview(w, r, query)
+}
+
+// servePreview sums up the feed the query makes, listing every item with
+// whether its filters keep it and which filters drop the ones they don't.
+// Without a filter every item is kept. Nothing is arranged, changed or marked
+// seen, and nothing is cached but the fetches.
+func servePreview(w http.ResponseWriter, r *http.Request, query url.Values) {
+	rules, err := parseRules(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parser, err := parseSource(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	headers, err := parseRequestHeaders(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithValue(r.Context(), requestHeadersKey{}, headers)
+
+	urls := query["url"]
+	if query.Has("opml") {
+		list, err := fetchOPML(ctx, query.Get("opml"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		urls = append(urls, list.feedURLs()...)
+	}
+	if len(urls) == 0 {
+		http.Error(w, "missing 'url' or 'opml'", http.StatusBadRequest)
+		return
+	}
+	warnings := &fetchWarnings{}
+	ctx = context.WithValue(ctx, fetchWarningsKey{}, warnings)
+	feed, _, err := fetchFeeds(ctx, urls, query["fallback"], parser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	summary := &preview{
+		Title:       feed.Title,
+		Link:        feed.Link,
+		Description: feed.Description,
+		Format:      feed.FeedType,
+		Total:       len(feed.Items),
+		Warnings:    warnings.list,
+		Items:       make([]previewItem, len(feed.Items)),
+	}
+	var undated, unlinked int
+	for i, item := range feed.Items {
+		summary.Items[i] = previewItem{jsonItem: newJSONItem(item)}
+		for _, rule := range rules {
+			if !rule.keep(item) {
+				summary.Items[i].DroppedBy = append(summary.Items[i].DroppedBy, rule.params)
+			}
+		}
+		if summary.Items[i].Kept = len(summary.Items[i].DroppedBy) == 0; summary.Items[i].Kept {
+			summary.Kept++
+		}
+		if summary.Items[i].Date == nil {
+			undated++
+		}
+		if item.Link == "" {
+			unlinked++
+		}
+	}
+	summary.Dropped = summary.Total - summary.Kept
+	if undated > 0 {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d of the items have no date, date filters keep them", undated))
+	}
+	if unlinked > 0 {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d of the items have no link", unlinked))
+	}
+	if summary.Warnings == nil {
+		summary.Warnings = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(summary)
+}
+
+// fetchWarningsKey holds the fetchWarnings of a preview in the context of its
+// fetches.
+type fetchWarningsKey struct{}
+
+type fetchWarnings struct {
+	mu   sync.Mutex
+	list []string
+}
+
+// warn adds the warning to the ones of the preview ctx is fetching for, if it
+// is.
+func warn(ctx context.Context, warning string) {
+	warnings, ok := ctx.Value(fetchWarningsKey{}).(*fetchWarnings)
+	if !ok {
+		return
+	}
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	warnings.list = append(warnings.list, warning)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The counts /metrics serves for Prometheus. Unlike the status page's they
+// start at zero with every start, as Prometheus expects of counters.
+var (
+	requestSeconds = newHistograms("rerss_request_duration_seconds", "How long requests took, by route and status code.",
+		[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+	fetchSeconds = newHistograms("rerss_upstream_fetch_duration_seconds", "How long fetches from the upstreams took.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+	upstreamFetches = newCounters("rerss_upstream_fetches_total", "Fetches from the upstreams, by host, past the first 100 hosts as other.")
+	upstreamErrors  = newCounters("rerss_upstream_fetch_errors_total", "Fetches from the upstreams that failed, by host, past the first 100 hosts as other.")
+	cacheLookups    = newCounters("rerss_cache_lookups_total", "Requests for feeds by whether the cache had them fresh, stale or missed them.")
+	filteredItems   = newCounters("rerss_items_total", "Items fetched by whether the filters kept or dropped them.")
+)
+
+var startTime = time.Now()
+
+// maxHostLabels is how many upstream hosts get series of their own, the rest
+// are counted as "other" so a public server's series don't grow with every
+// host it's asked for.
+const maxHostLabels = 100
+
+var hostLabels = struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+}{hosts: map[string]bool{}}
+
+// hostLabel is the host as the label of the upstream counts.
+func hostLabel(host string) string {
+	hostLabels.mu.Lock()
+	defer hostLabels.mu.Unlock()
+	if !hostLabels.hosts[host] {
+		if len(hostLabels.hosts) >= maxHostLabels {
+			return "other"
+		}
+		hostLabels.hosts[host] = true
+	}
+	return host
+}
+
+// countFiltered counts the items the filters of a feed kept and dropped.
+func countFiltered(kept, dropped int) {
+	filteredItems.add(float64(kept), "result", "kept")
+	filteredItems.add(float64(dropped), "result", "dropped")
+}
+
+// counters counts something by its labels, as a Prometheus counter.
+type counters struct {
+	name, help string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newCounters(name, help string) *counters {
+	return &counters{name: name, help: help, values: map[string]float64{}}
+}
+
+// add adds n to the count of the labels, given as name and value pairs.
+func (c *counters) add(n float64, labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[promLabels(labels...)] += n
+}
+
+func (c *counters) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, promHelpEscaper.Replace(c.help), c.name)
+	for _, labels := range slices.Sorted(maps.Keys(c.values)) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, promNumber(c.values[labels]))
+	}
+}
+
+// histograms are Prometheus histograms of durations by their labels.
+type histograms struct {
+	name, help string
+	bounds     []float64
+	mu         sync.Mutex
+	values     map[string]*histogram
+}
+
+type histogram struct {
+	// buckets count the observations up to the bound of the same index
+	buckets    []uint64
+	sum        float64
+	count      uint64
+	labelPairs []string
+}
+
+func newHistograms(name, help string, bounds []float64) *histograms {
+	return &histograms{name: name, help: help, bounds: bounds, values: map[string]*histogram{}}
+}
+
+func (h *histograms) observe(took time.Duration, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := promLabels(labels...)
+	value, found := h.values[key]
+	if !found {
+		value = &histogram{buckets: make([]uint64, len(h.bounds)), labelPairs: labels}
+		h.values[key] = value
+	}
+	seconds := took.Seconds()
+	for i, bound := range h.bounds {
+		if seconds <= bound {
+			value.buckets[i]++
+		}
+	}
+	value.sum += seconds
+	value.count++
+}
+
+func (h *histograms) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, promHelpEscaper.Replace(h.help), h.name)
+	for _, key := range slices.Sorted(maps.Keys(h.values)) {
+		value := h.values[key]
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, promLabels(append(slices.Clip(value.labelPairs), "le", promNumber(bound))...), value.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, promLabels(append(slices.Clip(value.labelPairs), "le", "+Inf")...), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, key, promNumber(value.sum), h.name, key, value.count)
+	}
+}
+
+// promEscaper escapes label values and promHelpEscaper HELP text, as the text
+// format has it.
+var (
+	promEscaper     = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	promHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// promLabels writes the name and value pairs as Prometheus labels, the values
+// escaped and made valid UTF-8 as they can come from the requests.
+func promLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+`="`+promEscaper.Replace(strings.ToValidUTF8(pairs[i+1], "\uFFFD"))+`"`)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func promNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// instrumented times the requests to the mux by the route they're for, which
+// the mux sets as their Pattern, and traces them.
+func instrumented(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		r = r.WithContext(ctx)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(recorder, r)
+		route := r.Pattern
+		if route == "" {
+			route = "none"
+		}
+		requestSeconds.observe(time.Since(started), "route", route, "code", strconv.Itoa(recorder.status))
+
+		span.SetName(r.Method + " " + strings.TrimPrefix(route, r.Method+" "))
+		span.SetAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.Int("http.response.status_code", recorder.status),
+		)
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// metricsHandler serves the counts in the Prometheus text format, along with
+// the Go runtime's.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	requestSeconds.write(w)
+	fetchSeconds.write(w)
+	upstreamFetches.write(w)
+	upstreamErrors.write(w)
+	cacheLookups.write(w)
+	filteredItems.write(w)
+
+	feeds, size := responses.size()
+	fmt.Fprintf(w, "# HELP rerss_cached_feeds Feeds in the memory cache.\n# TYPE rerss_cached_feeds gauge\nrerss_cached_feeds %d\n", feeds)
+	fmt.Fprintf(w, "# HELP rerss_cached_bytes Size of the feeds in the memory cache.\n# TYPE rerss_cached_bytes gauge\nrerss_cached_bytes %d\n", size)
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	for _, metric := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"go_goroutines", "gauge", "Goroutines that currently exist.", float64(runtime.NumGoroutine())},
+		{"go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(memStats.HeapAlloc)},
+		{"go_memstats_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.", float64(memStats.HeapInuse)},
+		{"go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", float64(memStats.Sys)},
+		{"go_memstats_mallocs_total", "counter", "Heap objects allocated.", float64(memStats.Mallocs)},
+		{"go_gc_cycles_total", "counter", "Completed GC cycles.", float64(memStats.NumGC)},
+		{"go_gc_pause_seconds_total", "counter", "Time the GC stopped the world for.", float64(memStats.PauseTotalNs) / 1e9},
+		{"process_start_time_seconds", "gauge", "When the process started, in Unix time.", float64(startTime.UnixNano()) / 1e9},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", metric.name, metric.help, metric.name, metric.kind, metric.name, promNumber(metric.value))
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPromEscaping(t *testing.T) {
+	counts := newCounters("rerss_test_total", "Counts by host,\nescaped like C:\\.")
+	counts.add(1, "host", "a\"b\\c\nd\xffe")
+	var written strings.Builder
+	counts.write(&written)
+	want := `# HELP rerss_test_total Counts by host,\nescaped like C:\\.
+# TYPE rerss_test_total counter
+rerss_test_total{host="a\"b\\c\nd` + "\uFFFD" + `e"} 1
+`
+	if written.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", written.String(), want)
+	}
+}
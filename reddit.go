@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// subredditPath matches subreddit listings, with their feed or JSON suffix.
+var subredditPath = regexp.MustCompile(`^/r/([\w+]+)(?:/(hot|new|top|rising|controversial))?/?(?:\.rss|\.json)?$`)
+
+// redditAPIURL is the JSON listing of the subreddit at the URL, it keeps its
+// sort and time range.
+func redditAPIURL(u *url.URL) (string, bool) {
+	if u.Host != "reddit.com" && u.Host != "www.reddit.com" && u.Host != "old.reddit.com" {
+		return "", false
+	}
+	match := subredditPath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return "", false
+	}
+	listing := "/r/" + match[1] + "/"
+	if match[2] != "" {
+		listing += match[2] + "/"
+	}
+	query := u.Query()
+	query.Set("limit", "100")
+	query.Set("raw_json", "1")
+	return (&url.URL{Scheme: "https", Host: "www.reddit.com", Path: listing + ".json", RawQuery: query.Encode()}).String(), true
+}
+
+type redditListing struct {
+	Data struct {
+		Children []struct {
+			Data struct {
+				Title        string  `json:"title"`
+				Permalink    string  `json:"permalink"`
+				URL          string  `json:"url"`
+				Author       string  `json:"author"`
+				Created      float64 `json:"created_utc"`
+				Score        int     `json:"score"`
+				Comments     int     `json:"num_comments"`
+				Flair        string  `json:"link_flair_text"`
+				IsSelf       bool    `json:"is_self"`
+				SelfTextHTML string  `json:"selftext_html"`
+				Subreddit    string  `json:"subreddit_name_prefixed"`
+			} `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+type redditParser struct{}
+
+// feed makes a feed of a subreddit listing. Along with the posts it keeps
+// their score, flair and kind for the Reddit filters.
+func (redditParser) feed(_ context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	var listing redditListing
+	if err := json.Unmarshal(page, &listing); err != nil {
+		return nil, fmt.Errorf("reddit: %w", err)
+	}
+
+	feed := &gofeed.Feed{FeedType: "rss"}
+	for _, child := range listing.Data.Children {
+		post := child.Data
+		if feed.Title == "" {
+			feed.Title = post.Subreddit
+			feed.Link = "https://www.reddit.com/" + post.Subreddit + "/"
+		}
+		comments := "https://www.reddit.com" + post.Permalink
+		published := time.Unix(int64(post.Created), 0).UTC()
+		item := &gofeed.Item{
+			Title:           post.Title,
+			Link:            post.URL,
+			GUID:            comments,
+			Published:       published.Format(time.RFC3339),
+			PublishedParsed: &published,
+			Author:          &gofeed.Person{Name: "u/" + post.Author},
+			Authors:         []*gofeed.Person{{Name: "u/" + post.Author}},
+			Description:     fmt.Sprintf(`<p><a href="%s">%d comments</a></p>`, html.EscapeString(comments), post.Comments),
+		}
+		kind := "link"
+		if post.IsSelf {
+			kind = "self"
+			item.Link = comments
+			item.Description = post.SelfTextHTML + item.Description
+		}
+		if post.Flair != "" {
+			item.Categories = []string{post.Flair}
+		}
+		setSiteValue(item, "reddit", "score", strconv.Itoa(post.Score))
+		setSiteValue(item, "reddit", "flair", post.Flair)
+		setSiteValue(item, "reddit", "kind", kind)
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
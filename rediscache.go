@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout is how long the cache waits for Redis, so a slow one doesn't
+// hold up the requests more than fetching the upstream would.
+const redisTimeout = 2 * time.Second
+
+// redisCache keeps the responses in Redis, shared by the instances using it.
+// Redis removes them once they've been stale for staleRetention.
+type redisCache struct {
+	client *redis.Client
+}
+
+func newRedisCache(redisURL string) (*redisCache, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{client: redis.NewClient(options)}, nil
+}
+
+func redisKey(key string) string {
+	return "rerss:response:" + key
+}
+
+func (c *redisCache) get(key string) (*cachedResponse, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	data, err := c.client.Get(ctx, redisKey(key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("reading from redis: %v", err)
+		}
+		return nil, false
+	}
+	response, err := decodeResponse(data)
+	if err != nil {
+		log.Printf("reading from redis: %v", err)
+		return nil, false
+	}
+	return response, true
+}
+
+func (c *redisCache) set(key string, response *cachedResponse) {
+	data, err := encodeResponse(response)
+	if err != nil {
+		log.Printf("caching in redis: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := c.client.Set(ctx, redisKey(key), data, time.Until(response.expires)+staleRetention).Err(); err != nil {
+		log.Printf("caching in redis: %v", err)
+	}
+}
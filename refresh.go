@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// refreshingKey marks the requests of the refresher in their context, they
+// skip the cache to put a fresh feed in it and set the *time.Time it holds to
+// when to refresh it next.
+type refreshingKey struct{}
+
+// refreshPinned keeps the pinned feeds in the cache, refreshing each of them
+// when nextRefresh says until ctx is done.
+func refreshPinned(ctx context.Context, pinned []string) {
+	for _, feedURL := range pinned {
+		go func() {
+			for {
+				next := refreshFeed(ctx, feedURL)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(next)):
+				}
+			}
+		}()
+	}
+}
+
+// refreshFeed makes the feed at the URL as if a reader asked for it and gives
+// when to refresh it next.
+func refreshFeed(ctx context.Context, feedURL string) time.Time {
+	next := time.Now().Add(cfg().RefreshInterval)
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, refreshingKey{}, &next), http.MethodGet, feedURL, nil)
+	if err != nil {
+		log.Printf("refreshing %s: %v", feedURL, err)
+		return next
+	}
+	if req.URL.Scheme == "https" {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	}
+	recorder := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		log.Printf("refreshing %s: %d %s", feedURL, recorder.Code, strings.TrimSpace(recorder.Body.String()))
+	}
+	return next
+}
+
+// nextRefresh is when to refresh the feed after now, once the refresh
+// interval or the feed's ttl has passed and outside of its skipHours.
+func nextRefresh(now time.Time, feed *gofeed.Feed) time.Time {
+	wait := cfg().RefreshInterval
+	if minutes, err := strconv.Atoi(feed.Custom["ttl"]); err == nil {
+		wait = max(wait, time.Duration(minutes)*time.Minute)
+	}
+	next := now.Add(wait)
+	skipHours := strings.Split(feed.Custom["skipHours"], ",")
+	for range 24 {
+		if !slices.Contains(skipHours, strconv.Itoa(next.UTC().Hour())) {
+			break
+		}
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+	return next
+}
+
+// scheduleTranslator keeps the ttl and skipHours of RSS feeds, which the
+// universal feed leaves out, in its Custom values.
+type scheduleTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *scheduleTranslator) Translate(feed any) (*gofeed.Feed, error) {
+	translated, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	if rssFeed, ok := feed.(*rss.Feed); ok {
+		if translated.Custom == nil {
+			translated.Custom = map[string]string{}
+		}
+		if ttl := strings.TrimSpace(rssFeed.TTL); ttl != "" {
+			translated.Custom["ttl"] = ttl
+		}
+		var hours []string
+		for _, hour := range rssFeed.SkipHours {
+			hours = append(hours, strings.TrimSpace(hour))
+		}
+		if len(hours) > 0 {
+			translated.Custom["skipHours"] = strings.Join(hours, ",")
+		}
+	}
+	return translated, nil
+}
+
+// feedParser parses every feed. With all its translators set it doesn't
+// change while parsing, so it's shared.
+var feedParser = newFeedParser()
+
+func newFeedParser() *gofeed.Parser {
+	parser := gofeed.NewParser()
+	parser.AtomTranslator = &gofeed.DefaultAtomTranslator{}
+	parser.RSSTranslator = &scheduleTranslator{}
+	parser.JSONTranslator = &gofeed.DefaultJSONTranslator{}
+	return parser
+}
+
+// parseFeed parses the feed, keeping what says when to fetch it again.
+func parseFeed(body []byte) (*gofeed.Feed, error) {
+	return feedParser.Parse(bytes.NewReader(body))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+)
+
+// reloadOnHangup reloads the config at path on every SIGHUP until ctx is
+// done, restarting the refreshes of the pinned feeds when they change.
+// Requests already being served finish with the config they started with.
+func reloadOnHangup(ctx context.Context, path string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	pinnedCtx, stopPinned := context.WithCancel(ctx)
+	refreshPinned(pinnedCtx, cfg().Pinned)
+	for {
+		select {
+		case <-ctx.Done():
+			stopPinned()
+			return
+		case <-hangups:
+		}
+		old := cfg()
+		conf, err := loadConfig(path)
+		if err != nil {
+			log.Printf("reloading the config: %v", err)
+			continue
+		}
+		for setting, changed := range map[string]bool{
+			"ip":             conf.IP != old.IP,
+			"port":           conf.Port != old.Port,
+			"read_timeout":   conf.ReadTimeout != old.ReadTimeout,
+			"write_timeout":  conf.WriteTimeout != old.WriteTimeout,
+			"data_dir":       conf.DataDir != old.DataDir,
+			"database_url":   conf.DatabaseURL != old.DatabaseURL,
+			"cache_dir":      conf.CacheDir != old.CacheDir,
+			"cache_max_size": conf.CacheMaxSize != old.CacheMaxSize,
+			"redis":          conf.Redis != old.Redis,
+			"log_format":     conf.LogFormat != old.LogFormat,
+			"otlp_endpoint":  conf.OTLPEndpoint != old.OTLPEndpoint,
+		} {
+			if changed {
+				log.Printf("reloading the config: %s only changes after a restart", setting)
+			}
+		}
+		currentConfig.Store(conf)
+		logLevel.Set(conf.logLevel)
+		log.Printf("reloaded %s", path)
+
+		if !slices.Equal(conf.Pinned, old.Pinned) {
+			stopPinned()
+			pinnedCtx, stopPinned = context.WithCancel(ctx)
+			refreshPinned(pinnedCtx, conf.Pinned)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+)
+
+type format struct {
+	contentType string
+	write       func(w io.Writer, feed *gofeed.Feed, opts *renderOptions) error
+}
+
+// renderOptions are the parameters that affect how the feed is written.
+type renderOptions struct {
+	format string
+	// eventDate finds the date of the event in an item's title for ics, its
+	// first group or whole match is the date
+	eventDate *regexp.Regexp
+	// stylesheet links RSS and Atom to feedXSL
+	stylesheet bool
+	// title and description replace the feed's own when set
+	title, description string
+	// self is the address the feed is served at
+	self string
+}
+
+func parseRender(query url.Values) (*renderOptions, error) {
+	opts := &renderOptions{
+		format:      query.Get("format"),
+		stylesheet:  query.Get("style") == "1",
+		title:       query.Get("title"),
+		description: query.Get("desc"),
+	}
+	if _, known := formats[opts.format]; opts.format != "" && !known {
+		return nil, fmt.Errorf("unknown 'format' %q", opts.format)
+	}
+	if query.Has("date_re") {
+		regex, err := regexp.Compile(query.Get("date_re"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'date_re': %w", err)
+		}
+		opts.eventDate = regex
+	}
+	return opts, nil
+}
+
+var formats = map[string]format{
+	"rss":      {"application/rss+xml; charset=utf-8", writeRSS},
+	"atom":     {"application/atom+xml; charset=utf-8", writeAtom},
+	"jsonfeed": {"application/feed+json; charset=utf-8", writeJSONFeed},
+	"json":     {"application/json; charset=utf-8", writeJSONItems},
+	"html":     {"text/html; charset=utf-8", writeHTML},
+	"md":       {"text/markdown; charset=utf-8", writeMarkdown},
+	"txt":      {"text/plain; charset=utf-8", writeText},
+	"ics":      {"text/calendar; charset=utf-8", writeICS},
+	"csv":      {"text/csv; charset=utf-8", writeCSV},
+}
+
+// sourceFormats maps gofeed's feed types to the format they're written back in.
+var sourceFormats = map[string]string{"rss": "rss", "atom": "atom", "json": "jsonfeed"}
+
+// renderFeed writes the feed in the named format. Without one the feed keeps
+// the format it came in, if it can be written back in it, and is RSS
+// otherwise.
+func renderFeed(feed *gofeed.Feed, opts *renderOptions) (contentType string, body []byte, err error) {
+	name := opts.format
+	if name == "" {
+		name = sourceFormats[feed.FeedType]
+	}
+	f, known := formats[name]
+	if !known {
+		f = formats["rss"]
+	}
+	if opts.title != "" {
+		feed.Title = opts.title
+	}
+	if opts.description != "" {
+		feed.Description = opts.description
+	}
+	var out bytes.Buffer
+	if err := f.write(&out, feed, opts); err != nil {
+		return "", nil, err
+	}
+	return f.contentType, out.Bytes(), nil
+}
+
+// The gorilla/feeds XML types are extended with what they can't hold, every
+// category of an item instead of one and namespaced elements like iTunes' and
+// Media RSS'.
+type (
+	rssFeedXML struct {
+		XMLName          xml.Name   `xml:"rss"`
+		Version          string     `xml:"version,attr"`
+		ContentNamespace string     `xml:"xmlns:content,attr"`
+		AtomNamespace    string     `xml:"xmlns:atom,attr,omitempty"`
+		Namespaces       []xml.Attr `xml:",any,attr"`
+		Channel          *rssChannel
+	}
+	rssChannel struct {
+		*feeds.RssFeed
+		SelfLink   *rssSelfLink
+		Extensions []xmlExtension
+		Items      []*rssItem `xml:"item"`
+		namespaces map[string]bool
+	}
+	rssSelfLink struct {
+		XMLName xml.Name `xml:"atom:link"`
+		Href    string   `xml:"href,attr"`
+		Rel     string   `xml:"rel,attr"`
+		Type    string   `xml:"type,attr"`
+	}
+	rssItem struct {
+		*feeds.RssItem
+		Categories []string `xml:"category"`
+		Extensions []xmlExtension
+	}
+
+	atomFeed struct {
+		*feeds.AtomFeed
+		// Links hides AtomFeed's only link to add the self link
+		Links      []*feeds.AtomLink `xml:"link"`
+		Namespaces []xml.Attr        `xml:",any,attr"`
+		Extensions []xmlExtension
+		Entries    []*atomEntry `xml:"entry"`
+	}
+	atomEntry struct {
+		*feeds.AtomEntry
+		Categories []atomCategory `xml:"category"`
+		Extensions []xmlExtension
+	}
+	atomCategory struct {
+		Term string `xml:"term,attr"`
+	}
+)
+
+func (channel *rssChannel) FeedXml() any {
+	rss := &rssFeedXML{
+		Version:          "2.0",
+		ContentNamespace: "http://purl.org/rss/1.0/modules/content/",
+		Namespaces:       namespaceAttrs(channel.namespaces),
+		Channel:          channel,
+	}
+	if channel.SelfLink != nil {
+		rss.AtomNamespace = "http://www.w3.org/2005/Atom"
+	}
+	return rss
+}
+
+func (feed *atomFeed) FeedXml() any {
+	return feed
+}
+
+func writeRSS(w io.Writer, feed *gofeed.Feed, opts *renderOptions) error {
+	channel := &rssChannel{RssFeed: (&feeds.Rss{Feed: toFeeds(feed)}).RssFeed(), namespaces: map[string]bool{}}
+	channel.Extensions = copyExtensions(feed.Extensions, channel.namespaces)
+	if opts.self != "" {
+		channel.SelfLink = &rssSelfLink{Href: opts.self, Rel: "self", Type: "application/rss+xml"}
+	}
+	for i, item := range channel.RssFeed.Items {
+		channel.Items = append(channel.Items, &rssItem{
+			RssItem:    item,
+			Categories: feed.Items[i].Categories,
+			Extensions: imageExtension(feed.Items[i], copyExtensions(feed.Items[i].Extensions, channel.namespaces), channel.namespaces),
+		})
+	}
+	return writeXML(w, channel, opts)
+}
+
+// writeAtom also fills in what the generic conversion leaves out of Atom, the
+// feed ID, its self link and when each entry was first published.
+func writeAtom(w io.Writer, feed *gofeed.Feed, opts *renderOptions) error {
+	atom := &atomFeed{AtomFeed: (&feeds.Atom{Feed: toFeeds(feed)}).AtomFeed()}
+	if feed.FeedLink != "" {
+		atom.Id = feed.FeedLink
+	}
+	if feed.Image != nil {
+		atom.Logo = feed.Image.URL
+	}
+	if atom.Link != nil {
+		atom.Links = append(atom.Links, atom.Link)
+	}
+	if opts.self != "" {
+		atom.Links = append(atom.Links, &feeds.AtomLink{Href: opts.self, Rel: "self", Type: "application/atom+xml"})
+	}
+	namespaces := map[string]bool{}
+	atom.Extensions = copyExtensions(feed.Extensions, namespaces)
+	for i, item := range feed.Items {
+		entry := &atomEntry{
+			AtomEntry:  atom.AtomFeed.Entries[i],
+			Extensions: imageExtension(item, copyExtensions(item.Extensions, namespaces), namespaces),
+		}
+		if item.PublishedParsed != nil {
+			entry.Published = item.PublishedParsed.Format(time.RFC3339)
+		}
+		for _, category := range item.Categories {
+			entry.Categories = append(entry.Categories, atomCategory{Term: category})
+		}
+		atom.Entries = append(atom.Entries, entry)
+	}
+	atom.Namespaces = namespaceAttrs(namespaces)
+	return writeXML(w, atom, opts)
+}
+
+//go:embed feed.xsl
+var feedXSL []byte
+
+// writeXML is feeds.WriteXML with the stylesheet browsers show the feed with.
+func writeXML(w io.Writer, feed feeds.XmlFeed, opts *renderOptions) error {
+	header := xml.Header
+	if opts.stylesheet {
+		header += `<?xml-stylesheet type="text/xsl" href="/feed.xsl"?>` + "\n"
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed.FeedXml())
+}
+
+// writeJSONFeed fills in what JSON Feed 1.1 requires and the generic
+// conversion leaves out, item IDs and content, along with tags and
+// attachments.
+func writeJSONFeed(w io.Writer, feed *gofeed.Feed, opts *renderOptions) error {
+	jsonFeed := (&feeds.JSON{Feed: toFeeds(feed)}).JSONFeed()
+	jsonFeed.Language = feed.Language
+	jsonFeed.FeedUrl = opts.self
+	if feed.Image != nil {
+		jsonFeed.Icon = feed.Image.URL
+	}
+	for i, item := range feed.Items {
+		jsonItem := jsonFeed.Items[i]
+		if jsonItem.Id == "" {
+			jsonItem.Id = itemID(item)
+		}
+		if jsonItem.ContentHTML == "" {
+			jsonItem.ContentHTML = item.Description
+		}
+		jsonItem.Tags = item.Categories
+		if item.Image != nil && jsonItem.Image == "" {
+			jsonItem.Image = item.Image.URL
+		}
+		for _, enclosure := range item.Enclosures {
+			size, _ := strconv.ParseInt(enclosure.Length, 10, 32)
+			jsonItem.Attachments = append(jsonItem.Attachments, feeds.JSONAttachment{
+				Url:      enclosure.URL,
+				MIMEType: enclosure.Type,
+				Size:     int32(size),
+			})
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(jsonFeed)
+}
+
+type jsonItem struct {
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	Link       string     `json:"link"`
+	Date       *time.Time `json:"date"`
+	Author     string     `json:"author"`
+	Categories []string   `json:"categories"`
+}
+
+func newJSONItem(item *gofeed.Item) jsonItem {
+	converted := jsonItem{
+		ID:         itemID(item),
+		Title:      item.Title,
+		Link:       item.Link,
+		Date:       itemDate(item),
+		Categories: item.Categories,
+	}
+	if item.Author != nil {
+		converted.Author = item.Author.Name
+	}
+	if converted.Categories == nil {
+		converted.Categories = []string{}
+	}
+	return converted
+}
+
+// writeJSONItems writes just the items as a plain JSON array, for scripts
+// that don't care about feeds.
+func writeJSONItems(w io.Writer, feed *gofeed.Feed, _ *renderOptions) error {
+	items := make([]jsonItem, len(feed.Items))
+	for i, item := range feed.Items {
+		items[i] = newJSONItem(item)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(items)
+}
+
+// writeCSV writes the items as a table for spreadsheets, categories are
+// joined by "; ".
+func writeCSV(w io.Writer, feed *gofeed.Feed, _ *renderOptions) error {
+	table := csv.NewWriter(w)
+	table.Write([]string{"title", "link", "date", "author", "categories"})
+	for _, item := range feed.Items {
+		var date, author string
+		if d := itemDate(item); d != nil {
+			date = d.Format(time.RFC3339)
+		}
+		if item.Author != nil {
+			author = item.Author.Name
+		}
+		table.Write([]string{item.Title, item.Link, date, author, strings.Join(item.Categories, "; ")})
+	}
+	table.Flush()
+	return table.Error()
+}
+
+//go:embed feed.html
+var feedHTML string
+
+// summaryLength is how much of an item's text the HTML preview shows.
+const summaryLength = 300
+
+var feedTemplate = template.Must(template.New("feed").Funcs(template.FuncMap{
+	"itemDate": itemDate,
+	"summary":  func(item *gofeed.Item) string { return truncate(itemText(item), summaryLength) },
+}).Parse(feedHTML))
+
+// writeHTML renders the feed as a page for checking a filter in the browser.
+// Item text is shown as plain text, so the upstream markup can't run here.
+func writeHTML(w io.Writer, feed *gofeed.Feed, _ *renderOptions) error {
+	return feedTemplate.Execute(w, feed)
+}
+
+const digestDateLayout = "2006-01-02 15:04 MST"
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`")
+
+// writeMarkdown writes a digest with a line per item, for pasting into chats
+// and emails.
+func writeMarkdown(w io.Writer, feed *gofeed.Feed, _ *renderOptions) error {
+	var digest strings.Builder
+	fmt.Fprintf(&digest, "# %s\n\n", markdownEscaper.Replace(feed.Title))
+	for _, item := range feed.Items {
+		title := markdownEscaper.Replace(item.Title)
+		if item.Link != "" {
+			title = fmt.Sprintf("[%s](<%s>)", title, item.Link)
+		}
+		digest.WriteString("- " + title)
+		if date := itemDate(item); date != nil {
+			digest.WriteString(" — " + date.Format(digestDateLayout))
+		}
+		digest.WriteString("\n")
+	}
+	_, err := io.WriteString(w, digest.String())
+	return err
+}
+
+// writeText writes a digest with a paragraph per item.
+func writeText(w io.Writer, feed *gofeed.Feed, _ *renderOptions) error {
+	var digest strings.Builder
+	digest.WriteString(feed.Title + "\n")
+	for _, item := range feed.Items {
+		digest.WriteString("\n" + item.Title + "\n")
+		if item.Link != "" {
+			digest.WriteString(item.Link + "\n")
+		}
+		if date := itemDate(item); date != nil {
+			digest.WriteString(date.Format(digestDateLayout) + "\n")
+		}
+	}
+	_, err := io.WriteString(w, digest.String())
+	return err
+}
+
+// feedModified is when the feed last changed, by its newest item or its own
+// date. It's zero if the feed has no dates.
+func feedModified(feed *gofeed.Feed) time.Time {
+	var modified time.Time
+	for _, date := range []*time.Time{feed.UpdatedParsed, feed.PublishedParsed} {
+		if date != nil && date.After(modified) {
+			modified = *date
+		}
+	}
+	for _, item := range feed.Items {
+		if date := itemDate(item); date != nil && date.After(modified) {
+			modified = *date
+		}
+	}
+	return modified
+}
+
+// toFeeds converts the parsed feed for writing.
+func toFeeds(originalFeed *gofeed.Feed) *feeds.Feed {
+	filteredFeed := &feeds.Feed{
+		Title:       originalFeed.Title,
+		Link:        &feeds.Link{Href: originalFeed.Link},
+		Description: originalFeed.Description,
+		Created:     feedModified(originalFeed),
+	}
+	if filteredFeed.Created.IsZero() {
+		filteredFeed.Created = time.Now()
+	}
+	if originalFeed.UpdatedParsed != nil {
+		filteredFeed.Updated = *originalFeed.UpdatedParsed
+	}
+	if originalFeed.Image != nil && originalFeed.Image.URL != "" {
+		filteredFeed.Image = &feeds.Image{Url: originalFeed.Image.URL, Title: originalFeed.Image.Title, Link: originalFeed.Link}
+		if filteredFeed.Image.Title == "" {
+			filteredFeed.Image.Title = originalFeed.Title
+		}
+	}
+	if originalFeed.Author != nil {
+		filteredFeed.Author = &feeds.Author{Name: originalFeed.Author.Name, Email: originalFeed.Author.Email}
+	}
+	for _, item := range originalFeed.Items {
+		filteredItem := &feeds.Item{
+			Title:       item.Title,
+			Link:        &feeds.Link{Href: item.Link},
+			Description: item.Description,
+			Content:     item.Content,
+			Id:          item.GUID,
+		}
+		if filteredItem.Id == "" {
+			filteredItem.Id = item.Link
+		}
+		if filteredItem.Id != "" {
+			filteredItem.IsPermaLink = strconv.FormatBool(filteredItem.Id == item.Link)
+		}
+		if date := itemDate(item); date != nil {
+			filteredItem.Created = *date
+		}
+		if item.UpdatedParsed != nil {
+			filteredItem.Updated = *item.UpdatedParsed
+		}
+		if item.Author != nil {
+			filteredItem.Author = &feeds.Author{Name: item.Author.Name, Email: item.Author.Email}
+		}
+		if len(item.Enclosures) > 0 {
+			filteredItem.Enclosure = toEnclosure(item.Enclosures[0])
+		}
+		filteredFeed.Items = append(filteredFeed.Items, filteredItem)
+	}
+
+	return filteredFeed
+}
+
+// toEnclosure fills in the length and type if the original feed left them out,
+// the RSS writer drops enclosures without them and podcast apps need both.
+func toEnclosure(enclosure *gofeed.Enclosure) *feeds.Enclosure {
+	length := enclosure.Length
+	if length == "" {
+		length = "0"
+	}
+	mimeType := enclosure.Type
+	if mimeType == "" {
+		mimeType = guessMIMEType(enclosure.URL)
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return &feeds.Enclosure{Url: enclosure.URL, Length: length, Type: mimeType}
+}
+
+// guessMIMEType tells the type of the file at the URL by its extension, it's
+// "" if the extension isn't known.
+func guessMIMEType(fileURL string) string {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return ""
+	}
+	return mime.TypeByExtension(path.Ext(u.Path))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+// scraper makes a feed of a page without one, every element matching item is
+// an item and the other selectors find its parts inside it.
+type scraper struct {
+	item, title, link, date cascadia.Selector
+}
+
+// parseScraper reads the selectors of the scrape mode, it's nil when the
+// sources are feeds.
+func parseScraper(query url.Values) (*scraper, error) {
+	if !query.Has("item") {
+		for _, name := range []string{"item_title", "item_link", "item_date"} {
+			if query.Has(name) {
+				return nil, fmt.Errorf("'%s' needs 'item'", name)
+			}
+		}
+		return nil, nil
+	}
+
+	s := &scraper{}
+	for name, selector := range map[string]*cascadia.Selector{
+		"item":       &s.item,
+		"item_title": &s.title,
+		"item_link":  &s.link,
+		"item_date":  &s.date,
+	} {
+		if !query.Has(name) {
+			continue
+		}
+		compiled, err := cascadia.Compile(query.Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s': %w", name, err)
+		}
+		*selector = compiled
+	}
+	return s, nil
+}
+
+// feed makes the feed of the page. Without selectors an item's title is all
+// of its text and the link is its first link.
+func (s *scraper) feed(_ context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &gofeed.Feed{Link: pageURL, FeedType: "rss"}
+	if title := cascadia.Query(doc, cascadia.MustCompile("head title")); title != nil {
+		feed.Title = nodeText(title)
+	}
+	for _, node := range s.item.MatchAll(doc) {
+		item := &gofeed.Item{Title: nodeText(node)}
+		if s.title != nil {
+			item.Title = ""
+			if title := s.title.MatchFirst(node); title != nil {
+				item.Title = nodeText(title)
+			}
+		}
+
+		linkSelector := s.link
+		if linkSelector == nil {
+			linkSelector = cascadia.MustCompile("a[href]")
+		}
+		if link := linkSelector.MatchFirst(node); link != nil {
+			if href, err := base.Parse(nodeAttr(link, "href")); err == nil {
+				item.Link = href.String()
+			}
+		}
+
+		if s.date != nil {
+			if date := s.date.MatchFirst(node); date != nil {
+				text := nodeAttr(date, "datetime")
+				if text == "" {
+					text = nodeText(date)
+				}
+				if published, _, ok := parseTextDate(text); ok {
+					item.Published = text
+					item.PublishedParsed = &published
+				}
+			}
+		}
+
+		if item.Title != "" || item.Link != "" {
+			feed.Items = append(feed.Items, item)
+		}
+	}
+	if len(feed.Items) == 0 {
+		return nil, errors.New("no items found with 'item'")
+	}
+	return feed, nil
+}
+
+func nodeText(node *html.Node) string {
+	var text strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			text.WriteString(node.Data + " ")
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+func nodeAttr(node *html.Node, name string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// seenRetention is how long an item is remembered after it last showed up
+// upstream.
+const seenRetention = 30 * 24 * time.Hour
+
+// seenStore remembers which items were already served for each feed and
+// filter, so newonly=1 can leave them out the next time. With a database it's
+// kept there and read every time, so instances sharing one agree.
+type seenStore struct {
+	mu sync.Mutex
+	db *stateDB // nil keeps everything in memory
+	// key -> item ID -> when it was last seen upstream, without a database
+	seen map[string]map[string]time.Time
+}
+
+var seenItems = &seenStore{seen: map[string]map[string]time.Time{}}
+
+// loadSeenStore keeps what was seen in the database. The seen.json of older
+// versions in dir is moved into it first.
+func loadSeenStore(db *stateDB, dir string) (*seenStore, error) {
+	store := &seenStore{db: db, seen: map[string]map[string]time.Time{}}
+	if db == nil || dir == "" {
+		return store, nil
+	}
+	if err := store.importJSON(filepath.Join(dir, "seen.json")); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *seenStore) importJSON(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var seen map[string]map[string]time.Time
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for key, ids := range seen {
+		for id, lastSeen := range ids {
+			if err := touchSeen(tx, key, id, lastSeen); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return os.Rename(path, path+".imported")
+}
+
+// unseen returns the items that weren't served for key before. It doesn't
+// mark anything, call markSeen with what was actually served.
+func (s *seenStore) unseen(key string, items []*gofeed.Item) ([]*gofeed.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	ids := s.seen[key]
+	if s.db != nil {
+		var err error
+		if ids, err = s.stored(key); err != nil {
+			return nil, err
+		}
+	}
+	var stillSeen []string
+	items = slices.DeleteFunc(items, func(item *gofeed.Item) bool {
+		id := itemID(item)
+		if _, found := ids[id]; found {
+			ids[id] = now
+			stillSeen = append(stillSeen, id)
+			return true
+		}
+		return false
+	})
+	return items, s.save(key, stillSeen, now)
+}
+
+func (s *seenStore) markSeen(key string, items []*gofeed.Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var served []string
+	for _, item := range items {
+		served = append(served, itemID(item))
+	}
+	if s.db != nil {
+		return s.save(key, served, now)
+	}
+	ids := s.seen[key]
+	if ids == nil {
+		ids = map[string]time.Time{}
+		s.seen[key] = ids
+	}
+	for _, id := range served {
+		ids[id] = now
+	}
+	for id, lastSeen := range ids {
+		if now.Sub(lastSeen) > seenRetention {
+			delete(ids, id)
+		}
+	}
+	return nil
+}
+
+// stored are the IDs seen for key in the database.
+func (s *seenStore) stored(key string) (map[string]time.Time, error) {
+	rows, err := s.db.Query(`SELECT id, last_seen FROM seen WHERE key = ?`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var lastSeen int64
+		if err := rows.Scan(&id, &lastSeen); err != nil {
+			return nil, err
+		}
+		ids[id] = time.Unix(lastSeen, 0)
+	}
+	return ids, rows.Err()
+}
+
+// save writes to the database that the IDs were seen for key now, and
+// forgets what wasn't seen for seenRetention for any key.
+func (s *seenStore) save(key string, ids []string, now time.Time) error {
+	if s.db == nil {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		if err := touchSeen(tx, key, id, now); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM seen WHERE last_seen < ?`, now.Add(-seenRetention).Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func touchSeen(tx *stateTx, key, id string, lastSeen time.Time) error {
+	_, err := tx.Exec(`INSERT INTO seen (key, id, last_seen) VALUES (?, ?, ?)
+		ON CONFLICT (key, id) DO UPDATE SET last_seen = excluded.last_seen`, key, id, lastSeen.Unix())
+	return err
+}
+
+// itemID identifies an item across fetches, preferring its GUID.
+func itemID(item *gofeed.Item) string {
+	switch {
+	case item.GUID != "":
+		return item.GUID
+	case item.Link != "":
+		return item.Link
+	default:
+		return item.Title
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"net/url"
+)
+
+// signature signs the path and the query with the URL secret, all of the
+// query but the signature and the key.
+func signature(path string, query url.Values) string {
+	query = maps.Clone(query)
+	query.Del("sig")
+	query.Del("key")
+	mac := hmac.New(sha256.New, []byte(cfg().URLSecret))
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedURL is the path with the query and its signature.
+func signedURL(path string, query url.Values) string {
+	signed := maps.Clone(query)
+	signed.Set("sig", signature(path, query))
+	return path + "?" + signed.Encode()
+}
+
+// validSignature is whether the request is signed with the URL secret, and
+// nothing in it changed since.
+func validSignature(r *http.Request) bool {
+	if cfg().URLSecret == "" {
+		return false
+	}
+	sig, err := hex.DecodeString(r.URL.Query().Get("sig"))
+	if err != nil {
+		return false
+	}
+	want, _ := hex.DecodeString(signature(r.URL.Path, r.URL.Query()))
+	return hmac.Equal(sig, want)
+}
+
+// signHandler signs the URL of the feed its query makes, for those allowed to
+// make feeds to share with those who aren't.
+func signHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg().URLSecret == "" {
+		http.Error(w, "no url_secret to sign with", http.StatusNotFound)
+		return
+	}
+	path := "/"
+	if user := r.PathValue("user"); user != "" {
+		path = "/u/" + user + "/"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(baseURL(r) + signedURL(path, r.URL.Query()) + "\n"))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+type sitemap struct {
+	XMLName xml.Name `xml:"urlset"`
+	URLs    []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+}
+
+// parseSitemap makes a feed of a sitemap's pages, ok is false if it's not a
+// sitemap. The pages are titled after the last part of their path, which is
+// usually a slug of their title.
+func parseSitemap(body []byte, sitemapURL string) (feed *gofeed.Feed, ok bool) {
+	var urlset sitemap
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&urlset); err != nil {
+		return nil, false
+	}
+
+	feed = &gofeed.Feed{FeedType: "rss"}
+	if site, err := url.Parse(sitemapURL); err == nil {
+		feed.Title = site.Host
+		feed.Link = (&url.URL{Scheme: site.Scheme, Host: site.Host, Path: "/"}).String()
+	}
+	for _, page := range urlset.URLs {
+		loc := strings.TrimSpace(page.Loc)
+		item := &gofeed.Item{Title: loc, Link: loc, Updated: page.LastMod}
+		if pageURL, err := url.Parse(loc); err == nil {
+			if slug := path.Base(strings.TrimSuffix(pageURL.Path, "/")); slug != "." && slug != "/" {
+				words := strings.FieldsFunc(strings.TrimSuffix(slug, path.Ext(slug)), func(r rune) bool { return r == '-' || r == '_' })
+				if len(words) > 0 {
+					item.Title = strings.Join(words, " ")
+				}
+			}
+		}
+		if updated, _, ok := parseTextDate(page.LastMod); ok {
+			item.UpdatedParsed = &updated
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, true
+}
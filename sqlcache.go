@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// sqlCache keeps the responses in the database, so a restart starts with them
+// instead of fetching every feed at once. Past maxSize the least recently
+// used ones are removed.
+type sqlCache struct {
+	db      *stateDB
+	maxSize int64
+}
+
+// get reads the response, marking it used.
+func (c *sqlCache) get(key string) (*cachedResponse, bool) {
+	var data []byte
+	err := c.db.QueryRow(`SELECT data FROM responses WHERE key = ?`, key).Scan(&data)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("reading from the database: %v", err)
+		}
+		return nil, false
+	}
+	response, err := decodeResponse(data)
+	if err != nil {
+		log.Printf("reading from the database: %v", err)
+		return nil, false
+	}
+	now := time.Now()
+	if now.After(response.expires.Add(staleRetention)) {
+		return nil, false
+	}
+	if _, err := c.db.Exec(`UPDATE responses SET used = ? WHERE key = ?`, now.UnixMilli(), key); err != nil {
+		log.Printf("caching in the database: %v", err)
+	}
+	return response, true
+}
+
+// set writes the response, then removes the ones stale for staleRetention and
+// the least recently used while they take more than maxSize.
+func (c *sqlCache) set(key string, response *cachedResponse) {
+	data, err := encodeResponse(response)
+	if err != nil {
+		log.Printf("caching in the database: %v", err)
+		return
+	}
+	now := time.Now()
+	if _, err := c.db.Exec(`INSERT INTO responses (key, data, size, expires, used) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET data = excluded.data, size = excluded.size, expires = excluded.expires, used = excluded.used`,
+		key, data, len(data), response.expires.Unix(), now.UnixMilli()); err != nil {
+		log.Printf("caching in the database: %v", err)
+		return
+	}
+	if _, err := c.db.Exec(`DELETE FROM responses WHERE expires < ? OR key IN (
+		SELECT key FROM (SELECT key, SUM(size) OVER (ORDER BY used DESC, key) AS total FROM responses) AS sizes WHERE total > ?)`,
+		now.Add(-staleRetention).Unix(), c.maxSize); err != nil {
+		log.Printf("evicting from the database: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+// serverStatus is how the server is doing, as /status shows it and
+// /status?format=json serves it.
+type serverStatus struct {
+	Build      buildStatus  `json:"build"`
+	Started    time.Time    `json:"started"`
+	UptimeS    int64        `json:"uptime_seconds"`
+	CPUPercent float64      `json:"cpu_percent"`
+	Memory     memoryStatus `json:"memory"`
+	Goroutines int          `json:"goroutines"`
+	Cache      cacheStatus  `json:"cache"`
+	// Fetches are the fetches from all the upstreams, Upstreams from each
+	// upstream host, only with the admin token
+	Fetches   upstreamStatus            `json:"fetches"`
+	Upstreams map[string]upstreamStatus `json:"upstreams,omitempty"`
+	// Failing are the upstream pages left alone after failing to fetch, only
+	// their hosts without the admin token
+	Failing map[string]failingStatus `json:"failing"`
+}
+
+type buildStatus struct {
+	Version   string    `json:"version"`
+	GoVersion string    `json:"go_version"`
+	Revision  string    `json:"revision,omitempty"`
+	Committed time.Time `json:"committed,omitzero"`
+	Built     time.Time `json:"built,omitzero"`
+	Modified  bool      `json:"modified,omitempty"`
+}
+
+// What the binary is, set when building it with
+// -ldflags "-X main.version=v1.2.0 -X main.commit=... -X main.buildDate=2025-06-01T12:00:00Z".
+// Go's build info fills in what isn't set.
+var version, commit, buildDate string
+
+type memoryStatus struct {
+	// ProcessBytes is what the Go runtime got from the OS, HeapBytes what of
+	// it holds objects
+	ProcessBytes uint64  `json:"process_bytes"`
+	HeapBytes    uint64  `json:"heap_bytes"`
+	UsedBytes    uint64  `json:"system_used_bytes"`
+	TotalBytes   uint64  `json:"system_total_bytes"`
+	UsedPercent  float64 `json:"system_used_percent"`
+}
+
+type cacheStatus struct {
+	Fresh  int `json:"fresh"`
+	Stale  int `json:"stale"`
+	Missed int `json:"missed"`
+	Feeds  int `json:"feeds"`
+	Bytes  int `json:"bytes"`
+}
+
+type upstreamStatus struct {
+	Fetches   int   `json:"fetches"`
+	Errors    int   `json:"errors"`
+	AverageMS int64 `json:"average_ms"`
+}
+
+func (u upstreamStatus) String() string {
+	return fmt.Sprintf("%d fetches, %d failed (%.0f%%), %d ms on average",
+		u.Fetches, u.Errors, float64(u.Errors)*100/float64(max(u.Fetches, 1)), u.AverageMS)
+}
+
+type failingStatus struct {
+	InARow  int       `json:"in_a_row"`
+	Error   string    `json:"error,omitempty"`
+	NextTry time.Time `json:"next_try"`
+}
+
+// build is what the binary was built from, as far as the ldflags and Go
+// recorded it.
+var build = sync.OnceValue(func() buildStatus {
+	status := buildStatus{Version: "unknown", GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		status.Version = info.Main.Version
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				status.Revision = setting.Value
+			case "vcs.time":
+				status.Committed, _ = time.Parse(time.RFC3339, setting.Value)
+			case "vcs.modified":
+				status.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if version != "" {
+		status.Version = version
+	}
+	if commit != "" {
+		status.Revision = commit
+	}
+	status.Built, _ = time.Parse(time.RFC3339, buildDate)
+	return status
+})
+
+// String is the build on one line, like the status page and -version show it.
+func (b buildStatus) String() string {
+	text := fmt.Sprintf("%s (%s)", b.Version, b.GoVersion)
+	if b.Revision != "" {
+		text += ", " + b.Revision
+		if b.Modified {
+			text += " modified"
+		}
+	}
+	if !b.Built.IsZero() {
+		text += ", built " + b.Built.Format(time.DateTime)
+	}
+	return text
+}
+
+// versionHandler serves what the binary was built from, to tell the
+// instances apart.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeAdminJSON(w, http.StatusOK, build())
+}
+
+// currentStatus is how the server is doing, with the fetches by host and the
+// failing pages in full for the admin.
+func currentStatus(admin bool) *serverStatus {
+	status := &serverStatus{
+		Build:      build(),
+		Started:    startTime.UTC(),
+		UptimeS:    int64(time.Since(startTime).Seconds()),
+		Goroutines: runtime.NumGoroutine(),
+		Failing:    upstreamFailures.status(admin),
+	}
+	if cpuUsages, _ := cpu.Percent(0, false); len(cpuUsages) > 0 {
+		status.CPUPercent = cpuUsages[0]
+	}
+	var goMem runtime.MemStats
+	runtime.ReadMemStats(&goMem)
+	status.Memory.ProcessBytes, status.Memory.HeapBytes = goMem.Sys, goMem.HeapAlloc
+	if sysMem, err := mem.VirtualMemory(); err == nil {
+		status.Memory.UsedBytes, status.Memory.TotalBytes, status.Memory.UsedPercent = sysMem.Used, sysMem.Total, sysMem.UsedPercent
+	}
+	status.Cache, status.Upstreams = stats.status()
+	for _, upstream := range status.Upstreams {
+		status.Fetches.Fetches += upstream.Fetches
+		status.Fetches.Errors += upstream.Errors
+		status.Fetches.AverageMS += upstream.AverageMS * int64(upstream.Fetches)
+	}
+	status.Fetches.AverageMS /= int64(max(status.Fetches.Fetches, 1))
+	if !admin {
+		status.Upstreams = nil
+	}
+	return status
+}
+
+// statusHandler shows how the server is doing, as text or with
+// ?format=json for dashboards. The upstream hosts and the failing pages with
+// their errors need the admin token as a bearer token, as they can have
+// private hosts and keys in them.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	status := currentStatus(validToken(token, []string{cfg().AdminToken}))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "json" {
+		writeAdminJSON(w, http.StatusOK, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	status.write(w)
+}
+
+const mb = 1 << 20
+
+func (s *serverStatus) write(w io.Writer) {
+	fmt.Fprintf(w, "Version:\t%s", s.Build)
+	fmt.Fprintf(w, "\nUp for:\t\t%s", time.Duration(s.UptimeS)*time.Second)
+	fmt.Fprintf(w, "\nCPU used:\t%.2f%%", s.CPUPercent)
+	fmt.Fprintf(w, "\nRAM used:\t%d / %d / %d MB (%.0f%%)",
+		s.Memory.ProcessBytes/mb, s.Memory.UsedBytes/mb, s.Memory.TotalBytes/mb, s.Memory.UsedPercent)
+	fmt.Fprintf(w, "\nGoroutines:\t%d", s.Goroutines)
+
+	lookups := max(s.Cache.Fresh+s.Cache.Stale+s.Cache.Missed, 1)
+	fmt.Fprintf(w, "\n\nCache:\t\t%d fresh, %d stale, %d missed (%.0f%% served from it)",
+		s.Cache.Fresh, s.Cache.Stale, s.Cache.Missed, float64(s.Cache.Fresh+s.Cache.Stale)*100/float64(lookups))
+	fmt.Fprintf(w, "\nCached:\t\t%d feeds, %d KB", s.Cache.Feeds, s.Cache.Bytes/1_024)
+	fmt.Fprintf(w, "\nFetched:\t%s", s.Fetches)
+	if len(s.Upstreams) > 0 {
+		fmt.Fprintf(w, "\n\nUpstreams:")
+		for _, host := range slices.Sorted(maps.Keys(s.Upstreams)) {
+			fmt.Fprintf(w, "\n%s\t%s", host, s.Upstreams[host])
+		}
+	}
+	if len(s.Failing) > 0 {
+		fmt.Fprintf(w, "\n\nFailing upstreams:")
+		for _, pageURL := range slices.Sorted(maps.Keys(s.Failing)) {
+			failure := s.Failing[pageURL]
+			fmt.Fprintf(w, "\n%s\t%d in a row, next try %s", pageURL, failure.InARow, failure.NextTry.Format(time.DateTime))
+			if failure.Error != "" {
+				fmt.Fprintf(w, ": %s", failure.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatusHosts checks that /status names the upstream hosts only to the
+// admin.
+func TestStatusHosts(t *testing.T) {
+	withConfig(t, &config{AdminToken: "admin"})
+	stats.fetched("https://private.example.com/feed.xml", time.Second, nil)
+
+	for _, test := range []struct {
+		bearer string
+		want   bool
+	}{
+		{"", false},
+		{"wrong", false},
+		{"admin", true},
+	} {
+		t.Run(test.bearer, func(t *testing.T) {
+			for target, total := range map[string]string{"/status": "Fetched:", "/status?format=json": `"fetches": {`} {
+				r := httptest.NewRequest(http.MethodGet, target, nil)
+				r.Header.Set("Authorization", "Bearer "+test.bearer)
+				recorder := httptest.NewRecorder()
+				statusHandler(recorder, r)
+				body := recorder.Body.String()
+				if got := strings.Contains(body, "private.example.com"); got != test.want {
+					t.Errorf("%s showed the host %v, want %v:\n%s", target, got, test.want, body)
+				}
+				if !strings.Contains(body, total) {
+					t.Errorf("%s didn't show the fetches:\n%s", target, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// unstreamable are the parameters that need the whole feed at once, or turn
+// it into something other than the RSS it came as.
+var unstreamable = []string{
+	"dedup", "sort", "order", "limit",
+	"rewrite", "rewrite_from", "rewrite_to", "prefix", "full", "fulltext",
+	"date_re", "title", "desc", "style",
+	"newonly", "opml", "fallback",
+}
+
+// streamable is whether the request only filters a single feed, which can be
+// done without rebuilding it if it's RSS.
+func streamable(query url.Values, parser pageParser) bool {
+	if parser != nil || len(query["url"]) != 1 || query.Get("format") != "" && query.Get("format") != "rss" {
+		return false
+	}
+	for _, name := range unstreamable {
+		if query.Has(name) {
+			return false
+		}
+	}
+	_, adapter := adapt(query.Get("url"))
+	return adapter == nil
+}
+
+// fetchStreamed fetches the feed of a streamable request. RSS comes back
+// filtered as the response, linking to self, anything else as the feed for
+// the usual path.
+func fetchStreamed(ctx context.Context, feedURL, self string, keepItem keepFunc) (*cachedResponse, *gofeed.Feed, error) {
+	body, err := fetchPage(ctx, feedURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	var kept, dropped int
+	var filtered bytes.Buffer
+	streamed, ok := streamRSS(bytes.NewReader(body), &filtered, self, func(item *gofeed.Item) bool {
+		if keepItem(item) {
+			kept++
+			return true
+		}
+		dropped++
+		return false
+	})
+	if !ok {
+		feed, err := readFeed(ctx, body, feedURL, nil)
+		return nil, feed, err
+	}
+	countFiltered(kept, dropped)
+	return &cachedResponse{
+		contentType: formats["rss"].contentType,
+		body:        filtered.Bytes(),
+		modified:    streamed.modified,
+		nextRefresh: nextRefresh(time.Now(), streamed.channel),
+	}, nil, nil
+}
+
+// streamedFeed is what streamRSS tells of the RSS feed it filtered.
+type streamedFeed struct {
+	// channel is the feed without its items
+	channel  *gofeed.Feed
+	modified time.Time
+}
+
+// streamBatch is how many items streamRSS parses at once.
+const streamBatch = 100
+
+// streamRSS filters the items of the RSS feed read from r to w a batch at a
+// time, copying the rest of it as it is but for the elements pointing readers
+// at the upstream, which the link to self replaces like writeRSS's. Only what
+// hasn't been written yet is kept. ok is false if it's not RSS 2.0 it can
+// read, which is for the usual path to deal with, throwing away what was
+// written.
+func streamRSS(r io.Reader, w io.Writer, self string, keepItem keepFunc) (streamed *streamedFeed, ok bool) {
+	read := &readBytes{r: r}
+	decoder := xml.NewDecoder(read)
+	var written error
+	write := func(b []byte) {
+		if written == nil {
+			_, written = w.Write(b)
+		}
+	}
+	var skeleton bytes.Buffer
+	// root and channel are the start tags, which wrap the items to parse them
+	// with the namespaces they declare
+	var root, channel []byte
+	var newest time.Time
+	// namespaces are the URIs of the prefixes the feed and its channel declare
+	namespaces := map[string]string{}
+
+	// batch are the items read and not yet filtered, each with the space
+	// before it, which is left out along with it
+	var batch [][2][]byte
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		wrapped := slices.Concat(root, channel)
+		for _, item := range batch {
+			wrapped = append(wrapped, item[1]...)
+		}
+		wrapped = append(wrapped, "</channel></rss>"...)
+		parsed, err := itemParser.Parse(bytes.NewReader(wrapped))
+		if err != nil {
+			return false
+		}
+		feed, err := itemTranslator.Translate(parsed)
+		if err != nil || len(feed.Items) != len(batch) {
+			return false
+		}
+		for i, item := range feed.Items {
+			if !keepItem(item) {
+				continue
+			}
+			write(batch[i][0])
+			write(batch[i][1])
+			if date := itemDate(item); date != nil && date.After(newest) {
+				newest = *date
+			}
+		}
+		batch = batch[:0]
+		return true
+	}
+	// pass writes what's up to end to the filtered feed and its skeleton
+	pass := func(end int64) {
+		write(read.upTo(end))
+		skeleton.Write(read.upTo(end))
+		read.drop(end)
+	}
+
+	depth := 0
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, false
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth <= 2 {
+				for _, attr := range token.Attr {
+					if attr.Name.Space == "xmlns" {
+						namespaces[attr.Name.Local] = attr.Value
+					}
+				}
+			}
+			if depth == 1 {
+				if token.Name.Local != "rss" {
+					return nil, false
+				}
+				root = bytes.Clone(read.between(start, decoder.InputOffset()))
+			}
+			if depth == 2 && token.Name.Local == "channel" {
+				channel = bytes.Clone(read.between(start, decoder.InputOffset()))
+				if self != "" {
+					pass(decoder.InputOffset())
+					write([]byte(`<atom:link xmlns:atom="` + rssNamespaces["atom"] + `" href="`))
+					var escaped bytes.Buffer
+					xml.EscapeText(&escaped, []byte(self))
+					write(escaped.Bytes())
+					write([]byte(`" rel="self" type="application/rss+xml"/>`))
+				}
+				continue
+			}
+			if depth == 3 && pointsAway(token, namespaces) {
+				if err := skipElement(decoder); err != nil || !flush() {
+					return nil, false
+				}
+				depth--
+				// the space before it goes with it, like an item's
+				before := bytes.TrimRight(read.upTo(start), " \t\r\n")
+				write(before)
+				skeleton.Write(before)
+				read.drop(decoder.InputOffset())
+				continue
+			}
+			if depth != 3 || token.Name.Local != "item" {
+				continue
+			}
+			if err := skipElement(decoder); err != nil {
+				return nil, false
+			}
+			depth--
+			end := decoder.InputOffset()
+			before := read.upTo(start)
+			if len(bytes.TrimSpace(before)) > 0 {
+				if !flush() {
+					return nil, false
+				}
+				pass(start)
+				before = nil
+			}
+			batch = append(batch, [2][]byte{bytes.Clone(before), bytes.Clone(read.between(start, end))})
+			read.drop(end)
+			if len(batch) == streamBatch && !flush() {
+				return nil, false
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if root == nil || !flush() {
+		return nil, false
+	}
+	pass(read.offset + int64(len(read.kept)))
+	if written != nil {
+		return nil, false
+	}
+
+	parsedChannel, err := parseFeed(skeleton.Bytes())
+	if err != nil {
+		return nil, false
+	}
+	modified := feedModified(parsedChannel)
+	if newest.After(modified) {
+		modified = newest
+	}
+	return &streamedFeed{channel: parsedChannel, modified: modified}, true
+}
+
+// readBytes keeps what's read from r from offset on, until it's dropped.
+type readBytes struct {
+	r      io.Reader
+	kept   []byte
+	offset int64
+}
+
+func (b *readBytes) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.kept = append(b.kept, p[:n]...)
+	return n, err
+}
+
+// between is what was read from start up to end, which must not have been
+// dropped.
+func (b *readBytes) between(start, end int64) []byte {
+	return b.kept[start-b.offset : end-b.offset]
+}
+
+// upTo is what was read and not dropped up to end.
+func (b *readBytes) upTo(end int64) []byte {
+	return b.between(b.offset, end)
+}
+
+// drop forgets what was read up to end.
+func (b *readBytes) drop(end int64) {
+	b.kept = append(b.kept[:0], b.kept[end-b.offset:]...)
+	b.offset = end
+}
+
+// rssNamespaces are the namespaces of the elements pointsAway knows.
+var rssNamespaces = map[string]string{"atom": "http://www.w3.org/2005/Atom", "itunes": extensionNamespaces["itunes"]}
+
+// pointsAway is whether the channel's element would point readers at the
+// upstream instead of the filtered feed: its links to itself and its hubs, and
+// the skippedExtensions.
+func pointsAway(element xml.StartElement, namespaces map[string]string) bool {
+	if element.Name.Space == "" {
+		return false
+	}
+	switch namespaces[element.Name.Space] {
+	case rssNamespaces["atom"]:
+		if element.Name.Local != "link" {
+			return false
+		}
+		for _, attr := range element.Attr {
+			if attr.Name.Local == "rel" && (attr.Value == "self" || attr.Value == "hub") {
+				return true
+			}
+		}
+	case rssNamespaces["itunes"]:
+		return skippedExtensions["itunes:"+element.Name.Local]
+	}
+	return false
+}
+
+var (
+	itemParser     = &rss.Parser{}
+	itemTranslator = &gofeed.DefaultRSSTranslator{}
+)
+
+// skipElement reads up to the end of the element just started.
+func skipElement(decoder *xml.Decoder) error {
+	for depth := 1; depth > 0; {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return err
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const podcastRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:a="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+<title>Podcast</title>
+<link>https://upstream.example.com/</link>
+<description>Episodes</description>
+<a:link href="https://upstream.example.com/feed.xml" rel="self" type="application/rss+xml"/>
+<a:link href="https://hub.example.com/" rel="hub"/>
+<itunes:new-feed-url>https://upstream.example.com/moved.xml</itunes:new-feed-url>
+<itunes:author>Host</itunes:author>
+<item><title>Go 1.24</title><link>https://upstream.example.com/1</link><guid>1</guid><pubDate>Tue, 11 Feb 2025 10:00:00 GMT</pubDate></item>
+<item><title>Rust 1.84</title><link>https://upstream.example.com/2</link><guid>2</guid><pubDate>Thu, 09 Jan 2025 10:00:00 GMT</pubDate></item>
+<item><title>Go 1.23</title><link>https://upstream.example.com/3</link><guid>3</guid><pubDate>Tue, 13 Aug 2024 10:00:00 GMT</pubDate></item>
+</channel>
+</rss>
+`
+
+const streamSelf = "https://rerss.example.com/f/podcast?format=rss&re=Go"
+
+func keepGo(item *gofeed.Item) bool { return strings.Contains(item.Title, "Go") }
+
+// TestStreamRSS checks that streaming a feed serves what rebuilding it does.
+func TestStreamRSS(t *testing.T) {
+	var streamed bytes.Buffer
+	result, ok := streamRSS(strings.NewReader(podcastRSS), &streamed, streamSelf, keepGo)
+	if !ok {
+		t.Fatal("couldn't stream the feed")
+	}
+	feed, err := parseFeed([]byte(podcastRSS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Items = slices.DeleteFunc(feed.Items, func(item *gofeed.Item) bool { return !keepGo(item) })
+	_, written, err := renderFeed(feed, &renderOptions{format: "rss", self: streamSelf})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range map[string][]byte{"streamed": streamed.Bytes(), "written": written} {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseFeed(body)
+			if err != nil {
+				t.Fatalf("%v in\n%s", err, body)
+			}
+			if got.Title != "Podcast" || got.Link != "https://upstream.example.com/" {
+				t.Errorf("got title %q and link %q", got.Title, got.Link)
+			}
+			if got.FeedLink != streamSelf {
+				t.Errorf("got self link %q, want %q", got.FeedLink, streamSelf)
+			}
+			var guids []string
+			for _, item := range got.Items {
+				guids = append(guids, item.GUID)
+			}
+			if !slices.Equal(guids, []string{"1", "3"}) {
+				t.Errorf("got items %v, want [1 3]", guids)
+			}
+			for _, upstream := range []string{"upstream.example.com/feed.xml", "hub.example.com", "new-feed-url"} {
+				if strings.Contains(string(body), upstream) {
+					t.Errorf("%s still in\n%s", upstream, body)
+				}
+			}
+		})
+	}
+	if want := feedModified(feed); !result.modified.Equal(want) {
+		t.Errorf("got modified %v, want %v", result.modified, want)
+	}
+}
+
+func TestStreamRSSOnlyRSS(t *testing.T) {
+	atom := `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Atom</title></feed>`
+	if _, ok := streamRSS(strings.NewReader(atom), io.Discard, streamSelf, keepGo); ok {
+		t.Error("streamed an Atom feed")
+	}
+	if _, ok := streamRSS(strings.NewReader(`<rss version="2.0"><channel><item>`), io.Discard, streamSelf, keepGo); ok {
+		t.Error("streamed a broken feed")
+	}
+}
+
+// TestStreamRSSChannelNamespaces checks that the items are filtered with the
+// namespaces the channel declares, read a byte at a time.
+func TestStreamRSSChannelNamespaces(t *testing.T) {
+	feed := `<?xml version="1.0"?>
+<rss version="2.0">
+<channel xmlns:c="http://purl.org/rss/1.0/modules/content/">
+<title>Blog</title>
+<item><title>1</title><guid>1</guid><c:encoded>All about Go</c:encoded></item>
+<item><title>2</title><guid>2</guid><c:encoded>All about Rust</c:encoded></item>
+</channel>
+</rss>
+`
+	aboutGo := func(item *gofeed.Item) bool { return strings.Contains(item.Content, "Go") }
+	var streamed bytes.Buffer
+	if _, ok := streamRSS(iotest.OneByteReader(strings.NewReader(feed)), &streamed, streamSelf, aboutGo); !ok {
+		t.Fatal("couldn't stream the feed")
+	}
+	got, err := parseFeed(streamed.Bytes())
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, streamed.Bytes())
+	}
+	if len(got.Items) != 1 || got.Items[0].GUID != "1" {
+		t.Errorf("got items %v in\n%s", got.Items, streamed.Bytes())
+	}
+	if !strings.Contains(streamed.String(), `<channel xmlns:c="http://purl.org/rss/1.0/modules/content/">`) {
+		t.Errorf("the channel lost its namespace in\n%s", streamed.Bytes())
+	}
+}
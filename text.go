@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+// plainText strips the markup from an HTML fragment.
+func plainText(fragment string) string {
+	var text strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(text.String()), " ")
+		case html.TextToken:
+			text.Write(tokenizer.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			text.WriteByte(' ')
+		}
+	}
+}
+
+// itemText is the item's body as plain text, its full content if there is
+// one or else the description.
+func itemText(item *gofeed.Item) string {
+	if item.Content != "" {
+		return plainText(item.Content)
+	}
+	return plainText(item.Description)
+}
+
+// truncate cuts the text to at most length characters, marking the cut.
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) > length {
+		return string(runes[:length]) + "…"
+	}
+	return text
+}
+
+// postTitleLength is how much of a post's text titles it, for posts on sites
+// that don't title them.
+const postTitleLength = 80
+
+// textDateLayouts are tried in order on dates written for people, like those
+// in titles or on web pages.
+var textDateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	time.DateOnly,
+	"02.01.2006",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// parseTextDate parses a date in any of textDateLayouts, hasTime tells if it
+// has the time of day too.
+func parseTextDate(text string) (date time.Time, hasTime, ok bool) {
+	for _, layout := range textDateLayouts {
+		if date, err := time.Parse(layout, strings.TrimSpace(text)); err == nil {
+			return date, strings.Contains(layout, "15"), true
+		}
+	}
+	return time.Time{}, false, false
+}
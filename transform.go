@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// transformFunc changes an item in place, source is the feed it came from.
+type transformFunc func(item *gofeed.Item, source *gofeed.Feed)
+
+// parseTransform reads the parameters that change the items that passed the
+// filter.
+func parseTransform(query url.Values) (transformFunc, error) {
+	var transforms []transformFunc
+
+	var rewrites []*titleRewrite
+	for _, expr := range query["rewrite"] {
+		rewrite, err := parseSedRewrite(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'rewrite' %q: %w", expr, err)
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	froms, tos := query["rewrite_from"], query["rewrite_to"]
+	if len(tos) > len(froms) {
+		return nil, errors.New("'rewrite_to' without a 'rewrite_from'")
+	}
+	for i, from := range froms {
+		regex, err := regexp.Compile(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'rewrite_from': %w", err)
+		}
+		rewrite := &titleRewrite{regex: regex, global: true}
+		if i < len(tos) {
+			rewrite.replacement = tos[i]
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	if len(rewrites) > 0 {
+		transforms = append(transforms, func(item *gofeed.Item, source *gofeed.Feed) {
+			for _, rewrite := range rewrites {
+				item.Title = rewrite.apply(item.Title)
+			}
+		})
+	}
+
+	if query.Get("full") == "1" {
+		transforms = append(transforms, func(item *gofeed.Item, source *gofeed.Feed) {
+			if item.Content != "" {
+				item.Description = item.Content
+			}
+		})
+	}
+	if query.Has("prefix") {
+		prefix := query.Get("prefix")
+		transforms = append(transforms, func(item *gofeed.Item, source *gofeed.Feed) {
+			item.Title = strings.ReplaceAll(prefix, "{feed}", source.Title) + item.Title
+		})
+	}
+
+	return func(item *gofeed.Item, source *gofeed.Feed) {
+		for _, transform := range transforms {
+			transform(item, source)
+		}
+	}, nil
+}
+
+type titleRewrite struct {
+	regex       *regexp.Regexp
+	replacement string // in regexp.Expand syntax
+	global      bool
+}
+
+func (rewrite *titleRewrite) apply(title string) string {
+	if rewrite.global {
+		return rewrite.regex.ReplaceAllString(title, rewrite.replacement)
+	}
+	match := rewrite.regex.FindStringSubmatchIndex(title)
+	if match == nil {
+		return title
+	}
+	replaced := rewrite.regex.ExpandString(nil, rewrite.replacement, title, match)
+	return title[:match[0]] + string(replaced) + title[match[1]:]
+}
+
+// parseSedRewrite reads a sed-style substitution like s/^BREAKING:\s*//,
+// where the delimiter is whatever follows the s, \1 to \9 and & in the
+// replacement refer to the match, and the g and i flags work as in sed.
+func parseSedRewrite(expr string) (*titleRewrite, error) {
+	if len(expr) < 2 || expr[0] != 's' {
+		return nil, errors.New("must look like s/pattern/replacement/")
+	}
+	delim := expr[1]
+	parts := splitUnescaped(expr[2:], delim)
+	if len(parts) != 3 {
+		return nil, errors.New("must look like s/pattern/replacement/")
+	}
+	pattern, replacement, flags := parts[0], parts[1], parts[2]
+
+	rewrite := &titleRewrite{replacement: sedReplacement(replacement)}
+	for _, flag := range flags {
+		switch flag {
+		case 'g':
+			rewrite.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return nil, fmt.Errorf("unknown flag %q", flag)
+		}
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rewrite.regex = regex
+	return rewrite, nil
+}
+
+// splitUnescaped splits s around delim, except where it's escaped with a
+// backslash. Escaped delimiters lose their backslash, other escapes are kept.
+func splitUnescaped(s string, delim byte) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			part.WriteByte(delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			part.WriteString(s[i : i+2])
+			i++
+		case s[i] == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(s[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+// sedReplacement converts a sed replacement to regexp.Expand syntax.
+func sedReplacement(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			out.WriteString("${" + s[i+1:i+2] + "}")
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			if s[i+1] == '$' {
+				out.WriteString("$$")
+			} else {
+				out.WriteByte(s[i+1])
+			}
+			i++
+		case s[i] == '&':
+			out.WriteString("${0}")
+		case s[i] == '$':
+			out.WriteString("$$")
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// youtubeFeedURL is the feed of the YouTube channel or playlist at the URL.
+// Channels by handle link to their feed, so they're found by discovery.
+func youtubeFeedURL(u *url.URL) (string, bool) {
+	if !youtubeHost(u.Host) {
+		return "", false
+	}
+	feed := url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/feeds/videos.xml"}
+	switch channel, isChannel := strings.CutPrefix(u.Path, "/channel/"); {
+	case u.Path == "/feeds/videos.xml":
+		feed.RawQuery = u.RawQuery
+	case isChannel && channel != "":
+		feed.RawQuery = url.Values{"channel_id": {strings.Trim(channel, "/")}}.Encode()
+	case u.Path == "/playlist" && u.Query().Has("list"):
+		feed.RawQuery = url.Values{"playlist_id": {u.Query().Get("list")}}.Encode()
+	default:
+		return "", false
+	}
+	return feed.String(), true
+}
+
+// youtubeHost tells if the host is YouTube's. Its /@handle pages are left to
+// the feeds they link to, as the channel ID isn't in the URL.
+func youtubeHost(host string) bool {
+	return host == "youtube.com" || host == "www.youtube.com" || host == "m.youtube.com"
+}
+
+// youtubeVideosBatch is how many videos the YouTube Data API tells about at
+// once.
+const youtubeVideosBatch = 50
+
+type youtubeParser struct{}
+
+// feed reads the channel's feed, and with YOUTUBE_API_KEY set asks the
+// YouTube Data API how long the videos are, which the feed doesn't say.
+func (youtubeParser) feed(ctx context.Context, page []byte, pageURL string) (*gofeed.Feed, error) {
+	feed, err := parseFeed(page)
+	if err != nil {
+		return nil, err
+	}
+	apiKey := cfg().YouTubeAPIKey
+	if apiKey == "" {
+		return feed, nil
+	}
+
+	videos := map[string]*gofeed.Item{}
+	var ids []string
+	for _, item := range feed.Items {
+		if values := item.Extensions["yt"]["videoId"]; len(values) > 0 {
+			videos[values[0].Value] = item
+			ids = append(ids, values[0].Value)
+		}
+	}
+	for start := 0; start < len(ids); start += youtubeVideosBatch {
+		batch := ids[start:min(start+youtubeVideosBatch, len(ids))]
+		durations, err := youtubeDurations(ctx, batch, apiKey)
+		if err != nil {
+			slog.Warn("asking YouTube how long the videos are failed", "error", err)
+			break
+		}
+		for id, duration := range durations {
+			if item, ok := videos[id]; ok {
+				setSiteValue(item, "youtube", "duration", strconv.Itoa(int(duration.Seconds())))
+			}
+		}
+	}
+	return feed, nil
+}
+
+// youtubeDurations asks the YouTube Data API how long the videos are. The key
+// is sent as a header, so it's not in the URL that's logged and shown in
+// /status.
+func youtubeDurations(ctx context.Context, ids []string, apiKey string) (map[string]time.Duration, error) {
+	ctx = context.WithValue(ctx, requestHeadersKey{}, http.Header{"X-Goog-Api-Key": {apiKey}})
+	query := url.Values{"part": {"contentDetails"}, "id": {strings.Join(ids, ",")}}
+	body, err := fetchPage(ctx, "https://www.googleapis.com/youtube/v3/videos?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var videos struct {
+		Items []struct {
+			ID             string `json:"id"`
+			ContentDetails struct {
+				Duration string `json:"duration"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &videos); err != nil {
+		return nil, err
+	}
+	durations := map[string]time.Duration{}
+	for _, video := range videos.Items {
+		if duration, ok := parseISODuration(video.ContentDetails.Duration); ok {
+			durations[video.ID] = duration
+		}
+	}
+	return durations, nil
+}
+
+var isoDuration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+// parseISODuration parses the durations YouTube gives, like PT1H2M3S.
+func parseISODuration(s string) (time.Duration, bool) {
+	match := isoDuration.FindStringSubmatch(s)
+	if match == nil {
+		return 0, false
+	}
+	var duration time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, _ := strconv.Atoi(match[i+1])
+		duration += time.Duration(n) * unit
+	}
+	return duration, true
+}